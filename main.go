@@ -36,6 +36,12 @@ func main() {
 
 	app := fiber.New()
 
+	// Relay push connections, which proxy.Do cannot forward
+	app.Use("/notifications/ws", proxyWebSocket(notificationService))
+
+	// Stream event streams as they arrive instead of buffering them
+	app.Get("/notifications/stream", proxyStream(notificationService))
+
 	app.Use(func(c *fiber.Ctx) error {
 		path := c.Path()
 
@@ -0,0 +1,125 @@
+// Command dlq inspects and re-drives events in the notification dead-letter topic.
+//
+//	go run ./notification_service/cmd/dlq list [-partition 0] [-offset 12] [-limit 50]
+//	go run ./notification_service/cmd/dlq show -partition 0 -offset 12
+//	go run ./notification_service/cmd/dlq redrive -partition 0 -offset 12
+//	go run ./notification_service/cmd/dlq redrive -all [-limit 100]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/events"
+	"notification_system/shared/broker"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dlq list|show|redrive [flags]")
+	os.Exit(2)
+}
+
+func main() {
+	// Connect to the database, where re-drives are recorded, which also
+	// loads the .env file
+	config.ConnectDatabase()
+
+	// Get the message broker from environment variables
+	brokerConfig, err := broker.ConfigFromEnv("notification_service_dlq")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Get the dead-letter topic from environment variables
+	deadLetterTopic := os.Getenv("KAFKA_DLQ_TOPIC")
+	if deadLetterTopic == "" {
+		deadLetterTopic = "friendship_events.dlq"
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	limit := flags.Int("limit", 50, "maximum number of dead letters to read, 0 for all")
+	partition := flags.Int("partition", -1, "dead-letter topic partition")
+	offset := flags.Int64("offset", -1, "dead-letter topic offset")
+	all := flags.Bool("all", false, "re-drive every dead letter never re-driven before, up to -limit")
+	flags.Parse(os.Args[2:])
+
+	publisher, err := broker.NewPublisher(brokerConfig)
+	if err != nil {
+		log.Fatal("Failed to connect to the message broker:", err)
+	}
+
+	scanner, err := broker.NewScanner(brokerConfig)
+	if err != nil {
+		log.Fatal("Failed to connect to the message broker:", err)
+	}
+
+	dlq := events.NewDeadLetterQueue(config.DB, publisher, scanner, deadLetterTopic)
+	defer dlq.Close()
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "list":
+		records, err := dlq.List(ctx, int32(*partition), max(*offset, 0), *limit)
+		if err != nil {
+			log.Fatal("Failed to read dead letters:", err)
+		}
+		for _, record := range records {
+			redriven := "-"
+			if record.RedrivenAt != nil {
+				redriven = record.RedrivenAt.Format("2006-01-02T15:04:05Z07:00")
+			}
+			fmt.Printf("%d/%d\t%s/%d/%d\tattempts=%d\t%s\tredriven=%s\t%s\n",
+				record.DLQPartition, record.DLQOffset,
+				record.Topic, record.Partition, record.Offset,
+				record.Attempts, record.FailedAt.Format("2006-01-02T15:04:05Z07:00"), redriven, record.Error)
+		}
+
+	case "show":
+		if *partition < 0 || *offset < 0 {
+			log.Fatal("show requires -partition and -offset")
+		}
+		record, err := dlq.Get(ctx, int32(*partition), *offset)
+		if err != nil {
+			log.Fatal("Failed to read dead letter:", err)
+		}
+		output, _ := json.MarshalIndent(record, "", "  ")
+		fmt.Println(string(output))
+		fmt.Println("payload:", string(record.Payload))
+
+	case "redrive":
+		var records []events.DeadLetterRecord
+		switch {
+		case *all:
+			records, err = dlq.Pending(ctx, *limit)
+		case *partition >= 0 && *offset >= 0:
+			var record events.DeadLetterRecord
+			record, err = dlq.Get(ctx, int32(*partition), *offset)
+			records = append(records, record)
+		default:
+			log.Fatal("redrive requires -partition and -offset, or -all")
+		}
+		if err != nil {
+			log.Fatal("Failed to read dead letters:", err)
+		}
+
+		for _, record := range records {
+			if err := dlq.Redrive(ctx, record); err != nil {
+				log.Fatalf("Failed to re-drive %d/%d: %v", record.DLQPartition, record.DLQOffset, err)
+			}
+			fmt.Printf("re-drove %d/%d to %s\n", record.DLQPartition, record.DLQOffset, record.Topic)
+		}
+
+	default:
+		usage()
+	}
+}
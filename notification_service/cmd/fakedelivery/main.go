@@ -0,0 +1,46 @@
+// Command fakedelivery runs local stand-ins for the services the delivery
+// adapters send to, so every adapter can be exercised without a network
+// connection. Everything received is logged and listed at GET /received.
+//
+//	go run ./notification_service/cmd/fakedelivery [-http :7070] [-smtp :2525]
+//
+// Point the adapters at it with
+//
+//	SMTP_ADDR=localhost:2525
+//	FCM_URL=http://localhost:7070/fcm/v1/projects/fake/messages:send
+//	APNS_URL=http://localhost:7070/apns
+//	DELIVERY_ALLOW_PRIVATE_NETWORKS=true
+//
+// and register delivery targets with a web push subscription created by
+// POST /webpush/subscriptions, or a webhook URL of http://localhost:7070/webhook.
+// Appending ?status=500 to a webhook or subscription URL makes it fail with
+// that status, and the device token "unregistered" is rejected as gone.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"net/http"
+
+	"notification_system/notification_service/delivery/fakedelivery"
+)
+
+func main() {
+	httpAddr := flag.String("http", ":7070", "address of the web push, FCM, APNs and webhook endpoints")
+	smtpAddr := flag.String("smtp", ":2525", "address of the SMTP server")
+	flag.Parse()
+
+	server := fakedelivery.NewServer()
+
+	listener, err := net.Listen("tcp", *smtpAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		log.Fatal(server.ServeSMTP(listener))
+	}()
+
+	log.Printf("Fake delivery endpoints on %s, SMTP on %s", *httpAddr, *smtpAddr)
+	log.Fatal(http.ListenAndServe(*httpAddr, server.Handler()))
+}
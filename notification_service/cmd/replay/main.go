@@ -0,0 +1,206 @@
+// Command replay rebuilds notifications by feeding the events stored in a
+// topic through the same ingestion code as the consumer, which applies the
+// receivers' current preferences. Out-of-band deliveries are not sent again.
+//
+//	go run ./notification_service/cmd/replay [-offset 0 | -from 2025-01-01T00:00:00Z] [-partition 0]
+//	go run ./notification_service/cmd/replay -dry-run
+//	go run ./notification_service/cmd/replay -live
+//
+// By default notifications are written to the notifications_replay shadow
+// table, which can be compared with the live table before swapping them.
+// -live writes to the live table instead; events already stored there are
+// skipped, so only missing notifications are added.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/events"
+	"notification_system/notification_service/models"
+	"notification_system/shared/broker"
+
+	"gorm.io/gorm"
+)
+
+// replayStats counts what happened to the replayed messages
+type replayStats struct {
+	scanned       int
+	stored        int
+	duplicates    int
+	skipped       int
+	purged        int
+	unprocessable int
+}
+
+func main() {
+	topic := flag.String("topic", "friendship_events", "topic to replay")
+	offset := flag.Int64("offset", 0, "first offset to replay in each partition")
+	from := flag.String("from", "", "replay messages produced at or after this RFC 3339 time instead of -offset")
+	partition := flag.Int("partition", -1, "only replay this partition")
+	live := flag.Bool("live", false, "write to the live notifications table instead of the shadow table")
+	shadowTable := flag.String("table", "notifications_replay", "shadow table to write to")
+	dryRun := flag.Bool("dry-run", false, "decode events and count what would be stored without writing")
+	every := flag.Int("progress", 1000, "report progress every n messages")
+	flag.Parse()
+
+	position := broker.Position{Offset: *offset}
+	if *from != "" {
+		fromTime, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			log.Fatal("Invalid -from time:", err)
+		}
+		position.Time = fromTime
+	}
+
+	// Connect to the database, which also loads the .env file
+	config.ConnectDatabase()
+
+	// Get the message broker from environment variables
+	brokerConfig, err := broker.ConfigFromEnv("notification_service_replay")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scanner, err := broker.NewScanner(brokerConfig)
+	if err != nil {
+		log.Fatal("Failed to connect to the message broker:", err)
+	}
+	defer scanner.Close()
+
+	table := *shadowTable
+	if *live {
+		table = "notifications"
+	}
+
+	// Create the shadow table with the same columns and indexes as the live one
+	if !*live && !*dryRun {
+		if err := config.DB.Table(table).AutoMigrate(&models.Notification{}); err != nil {
+			log.Fatal("Failed to create shadow table:", err)
+		}
+	}
+
+	db := config.DB.Table(table).Session(&gorm.Session{})
+	tableExists := config.DB.Migrator().HasTable(table)
+	seen := make(map[string]bool)
+
+	// Stop after the current message on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var stats replayStats
+	var ingestErr error
+	started := time.Now()
+	log.Printf("Replaying %s into %s (dry run: %t)", *topic, table, *dryRun)
+
+	err = scanner.ScanFrom(ctx, *topic, position, func(msg *broker.Message) bool {
+		if *partition >= 0 && msg.Partition != int32(*partition) {
+			return true
+		}
+
+		stats.scanned++
+
+		var ingested events.Ingested
+		if *dryRun {
+			ingested, ingestErr = wouldIngest(db, tableExists, seen, msg)
+		} else {
+			ingested, ingestErr = events.IngestMessage(db, msg)
+		}
+
+		switch {
+		case errors.Is(ingestErr, events.ErrUnprocessable):
+			log.Printf("Skipped unprocessable message %d/%d: %v", msg.Partition, msg.Offset, ingestErr)
+			stats.unprocessable++
+			ingestErr = nil
+		case ingestErr != nil:
+			log.Printf("Failed to store message %d/%d, resume with -partition %d -offset %d: %v",
+				msg.Partition, msg.Offset, msg.Partition, msg.Offset, ingestErr)
+			return false
+		case ingested.Skipped:
+			stats.skipped++
+		case ingested.Purged:
+			stats.purged++
+		case ingested.Stored:
+			stats.stored++
+		default:
+			stats.duplicates++
+		}
+
+		if *every > 0 && stats.scanned%*every == 0 {
+			log.Printf("Progress: partition %d offset %d (%s), %s",
+				msg.Partition, msg.Offset, msg.Timestamp.Format(time.RFC3339), stats.summary(*dryRun, time.Since(started)))
+		}
+		return true
+	})
+	if err == nil {
+		err = ingestErr
+	}
+
+	log.Printf("Replay finished: %s", stats.summary(*dryRun, time.Since(started)))
+	if errors.Is(err, broker.ErrIncompleteScan) {
+		// Partitions the scan gave up on were not replayed to their end
+		log.Fatal("Replay incomplete, resume each partition below with -partition and -offset from where it stopped: ", err)
+	}
+	if err != nil {
+		log.Fatal("Replay stopped early: ", err)
+	}
+}
+
+// wouldIngest reports what ingesting a message would do, without writing
+// anything
+func wouldIngest(db *gorm.DB, tableExists bool, seen map[string]bool, msg *broker.Message) (events.Ingested, error) {
+	var ingested events.Ingested
+	var err error
+	ingested.Envelope, ingested.Event, err = events.DecodeNotification(msg)
+	if err != nil {
+		return ingested, err
+	}
+
+	eventID := events.EventID(msg, ingested.Envelope)
+	if ingested.Purged, err = events.WasPurged(eventID); err != nil || ingested.Purged {
+		return ingested, err
+	}
+
+	var enabled bool
+	ingested.Channels, enabled, err = events.ReceiverChannels(ingested.Event)
+	if err != nil || !enabled {
+		ingested.Skipped = err == nil
+		return ingested, err
+	}
+
+	if seen[eventID] {
+		return ingested, nil
+	}
+	seen[eventID] = true
+
+	if !tableExists {
+		ingested.Stored = true
+		return ingested, nil
+	}
+
+	var count int64
+	if err := db.Unscoped().Where("event_id = ?", eventID).Count(&count).Error; err != nil {
+		return ingested, err
+	}
+	ingested.Stored = count == 0
+	return ingested, nil
+}
+
+func (s replayStats) summary(dryRun bool, elapsed time.Duration) string {
+	verb := "stored"
+	if dryRun {
+		verb = "would store"
+	}
+
+	rate := float64(s.scanned) / max(elapsed.Seconds(), 0.001)
+	return fmt.Sprintf("%d scanned, %d %s, %d duplicates, %d disabled by preferences, %d purged, %d unprocessable, %.0f msg/s",
+		s.scanned, s.stored, verb, s.duplicates, s.skipped, s.purged, s.unprocessable, rate)
+}
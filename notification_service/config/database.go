@@ -30,5 +30,5 @@ func ConnectDatabase() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	DB.AutoMigrate(&models.Notification{})
+	DB.AutoMigrate(&models.Notification{}, &models.ExpiredNotification{}, &models.PurgedEvent{}, &models.DeadLetterRedrive{}, &models.NotificationPreference{}, &models.QuietHours{}, &models.DeferredDelivery{}, &models.DeliveryTarget{}, &models.Delivery{})
 }
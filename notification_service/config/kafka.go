@@ -4,40 +4,40 @@ import (
 	"log"
 	"os"
 
-	"github.com/IBM/sarama"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
+
 	"github.com/joho/godotenv"
 )
 
-var KafkaProducer sarama.SyncProducer
-var KafkaConsumer sarama.Consumer
+// KafkaProducer publishes notification lifecycle events to NotificationTopic
+var KafkaProducer broker.Publisher
+
+// NotificationTopic receives notification_delivered and notification_read events
+var NotificationTopic string
 
-func InitKafka() {
+// EventFormat is the encoding of published events
+var EventFormat sharedevents.Format
+
+func InitKafka(publisher broker.Publisher) {
 	// Load environment variables from .env file
 	err := godotenv.Load()
 	if err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
-	// Get the Kafka broker from environment variables
-	kafkaBroker := os.Getenv("KAFKA_BROKER")
-	if kafkaBroker == "" {
-		log.Fatal("KAFKA_BROKER environment variable not set")
-	}
-
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
-
-	producer, err := sarama.NewSyncProducer([]string{kafkaBroker}, config)
-	if err != nil {
-		log.Fatal("Failed to start Kafka producer:", err)
+	// Get the notification events topic from environment variables
+	NotificationTopic = os.Getenv("KAFKA_NOTIFICATION_TOPIC")
+	if NotificationTopic == "" {
+		NotificationTopic = "notification_events"
 	}
 
-	consumer, err := sarama.NewConsumer([]string{kafkaBroker}, config)
+	// Get the event encoding from environment variables, defaulting to the JSON envelope
+	EventFormat, err = sharedevents.ParseFormat(os.Getenv("KAFKA_EVENT_FORMAT"))
 	if err != nil {
-		log.Fatal("Failed to start Kafka consumer:", err)
+		log.Fatal("Invalid KAFKA_EVENT_FORMAT:", err)
 	}
 
-	KafkaProducer = producer
-	KafkaConsumer = consumer
-	log.Println("Kafka producer and consumer started")
+	KafkaProducer = publisher
+	log.Println("Kafka producer started for", NotificationTopic)
 }
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// PushChannel is the Redis channel replicas fan pushed notifications out on
+var PushChannel string
+
+// PushHeartbeat is the interval between pings on push connections; a
+// connection that misses two pings is closed
+var PushHeartbeat time.Duration
+
+// PushMaxConnections is the number of push connections a user may hold
+// across every replica, or on each replica when Redis is not configured; 0
+// means no limit
+var PushMaxConnections int
+
+func InitPush() {
+	// Load environment variables from .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	// Get the Redis channel from environment variables
+	PushChannel = os.Getenv("PUSH_REDIS_CHANNEL")
+	if PushChannel == "" {
+		PushChannel = "notification_push"
+	}
+
+	// Get the heartbeat interval from environment variables
+	PushHeartbeat = 30 * time.Second
+	if value := os.Getenv("PUSH_HEARTBEAT_INTERVAL"); value != "" {
+		PushHeartbeat, err = time.ParseDuration(value)
+		if err != nil || PushHeartbeat <= 0 {
+			log.Fatal("PUSH_HEARTBEAT_INTERVAL must be a positive duration")
+		}
+	}
+
+	// Get the connection limit per user from environment variables
+	PushMaxConnections = 5
+	if value := os.Getenv("PUSH_MAX_CONNECTIONS_PER_USER"); value != "" {
+		PushMaxConnections, err = strconv.Atoi(value)
+		if err != nil || PushMaxConnections < 0 {
+			log.Fatal("PUSH_MAX_CONNECTIONS_PER_USER must be a number of at least 0")
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/joho/godotenv"
+)
+
+// Redis is shared by every replica; it is nil when REDIS_URL is not set
+var Redis *redis.Client
+
+func ConnectRedis() {
+	// Load environment variables from .env file
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+
+	// Get the Redis URL from environment variables; Redis is optional
+	url := os.Getenv("REDIS_URL")
+	if url == "" {
+		log.Println("REDIS_URL not set, running without Redis")
+		return
+	}
+
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		log.Fatal("Invalid REDIS_URL:", err)
+	}
+
+	Redis = redis.NewClient(options)
+	if err := Redis.Ping(context.Background()).Err(); err != nil {
+		log.Fatal("Failed to connect to Redis:", err)
+	}
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strconv"
+
+	"notification_system/notification_service/events"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Middleware to restrict admin endpoints to callers presenting ADMIN_TOKEN.
+// Admin endpoints are disabled when ADMIN_TOKEN is not set.
+func RequireAdmin(c *fiber.Ctx) error {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		return c.Status(403).JSON(fiber.Map{"error": "Admin endpoints are disabled"})
+	}
+
+	if subtle.ConstantTimeCompare([]byte(c.Get("X-Admin-Token")), []byte(adminToken)) != 1 {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	return c.Next()
+}
+
+// Helper function to parse the dead-letter position from the route parameters
+func getDeadLetterPosition(c *fiber.Ctx) (int32, int64, error) {
+	partition, err := strconv.ParseInt(c.Params("partition"), 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offset, err := strconv.ParseInt(c.Params("offset"), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int32(partition), offset, nil
+}
+
+// List dead-lettered events, optionally of one partition and from an offset on
+func ListDeadLetters(c *fiber.Ctx) error {
+	partition := c.QueryInt("partition", -1)
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid dead letter position"})
+	}
+
+	records, err := events.DeadLetters.List(c.UserContext(), int32(partition), int64(offset), c.QueryInt("limit", 50))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read dead letters"})
+	}
+
+	return c.JSON(records)
+}
+
+// Get a single dead-lettered event
+func GetDeadLetter(c *fiber.Ctx) error {
+	partition, offset, err := getDeadLetterPosition(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid dead letter position"})
+	}
+
+	record, err := events.DeadLetters.Get(c.UserContext(), partition, offset)
+	if errors.Is(err, events.ErrDeadLetterNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Dead letter not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read dead letters"})
+	}
+
+	return c.JSON(record)
+}
+
+// Re-drive a single dead-lettered event to its original topic
+func RedriveDeadLetter(c *fiber.Ctx) error {
+	partition, offset, err := getDeadLetterPosition(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid dead letter position"})
+	}
+
+	record, err := events.DeadLetters.Get(c.UserContext(), partition, offset)
+	if errors.Is(err, events.ErrDeadLetterNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Dead letter not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read dead letters"})
+	}
+
+	if err := events.DeadLetters.Redrive(c.UserContext(), record); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to re-drive dead letter"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Dead letter re-driven"})
+}
+
+// Re-drive up to limit dead-lettered events that were never re-driven to
+// their original topics
+func RedriveDeadLetters(c *fiber.Ctx) error {
+	records, err := events.DeadLetters.Pending(c.UserContext(), c.QueryInt("limit", 0))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read dead letters"})
+	}
+
+	redriven := 0
+	for _, record := range records {
+		if err := events.DeadLetters.Redrive(c.UserContext(), record); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to re-drive dead letters", "redriven": redriven})
+		}
+		redriven++
+	}
+
+	return c.JSON(fiber.Map{"message": "Dead letters re-driven", "redriven": redriven})
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/events"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/unread"
+	sharedevents "notification_system/shared/events"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Most notifications a batch request may name
+const maxBatchSize = 1000
+
+// notificationBatch is the body of batch requests
+type notificationBatch struct {
+	IDs []uint `json:"ids"`
+}
+
+// readAllRequest is the optional body of POST /notifications/read-all
+type readAllRequest struct {
+	Topic  string     `json:"topic"`
+	Before *time.Time `json:"before"`
+}
+
+// Helper function to parse the notification IDs of a batch request
+func parseNotificationBatch(c *fiber.Ctx) ([]uint, error) {
+	var batch notificationBatch
+	if err := c.BodyParser(&batch); err != nil {
+		return nil, errors.New("request body must be a JSON object with an ids list")
+	}
+
+	if len(batch.IDs) == 0 {
+		return nil, errors.New("ids must not be empty")
+	}
+	if len(batch.IDs) > maxBatchSize {
+		return nil, fmt.Errorf("ids must not name more than %d notifications", maxBatchSize)
+	}
+	return batch.IDs, nil
+}
+
+// Helper function to refresh the unread count of a user after a batch changed any of it
+func refreshUnreadCount(c *fiber.Ctx, userID uint, changed int64) {
+	if changed == 0 {
+		return
+	}
+	if err := unread.Refresh(c.UserContext(), userID); err != nil {
+		log.Println("Failed to update unread count:", err)
+	}
+}
+
+// Helper function to mark the notifications of a user selected by filter as
+// read. It returns the notifications it changed, so each read can be reported.
+func markNotificationsAsRead(userID uint, filter func(*gorm.DB) *gorm.DB) ([]models.Notification, error) {
+	var changed []models.Notification
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the rows so a concurrent read cannot report them too
+		err := filter(tx.Clauses(clause.Locking{Strength: "UPDATE"})).
+			Where("receiver_id = ? AND status <> ?", userID, "read").
+			Order("id").
+			Find(&changed).Error
+		if err != nil || len(changed) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(changed))
+		for i, notification := range changed {
+			ids[i] = notification.ID
+		}
+		for start := 0; start < len(ids); start += maxBatchSize {
+			end := min(start+maxBatchSize, len(ids))
+			if err := tx.Model(&models.Notification{}).Where("id IN ?", ids[start:end]).Update("status", "read").Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// Helper function to publish a read receipt for each notification a request
+// marked as read. Receipts are best effort, like those of MarkAsRead.
+func publishReadReceipts(c *fiber.Ctx, notifications []models.Notification) {
+	for _, notification := range notifications {
+		if err := events.PublishReceipt(c.UserContext(), sharedevents.NotificationRead, notification, getCorrelationID(c)); err != nil {
+			log.Println("Failed to publish read receipt:", err)
+		}
+	}
+}
+
+// Mark every unread notification of a user as read, optionally only those
+// of a topic or created before a time
+func MarkAllAsRead(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var request readAllRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+		}
+	}
+
+	changed, err := markNotificationsAsRead(userID, func(query *gorm.DB) *gorm.DB {
+		if request.Topic != "" {
+			query = query.Where("topic = ?", request.Topic)
+		}
+		if request.Before != nil {
+			query = query.Where("created_at < ?", *request.Before)
+		}
+		return query
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update notifications"})
+	}
+
+	refreshUnreadCount(c, userID, int64(len(changed)))
+	publishReadReceipts(c, changed)
+	return c.JSON(fiber.Map{"updated": len(changed)})
+}
+
+// Mark a batch of notifications as read
+func MarkBatchAsRead(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	ids, err := parseNotificationBatch(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	changed, err := markNotificationsAsRead(userID, func(query *gorm.DB) *gorm.DB {
+		return query.Where("id IN ?", ids)
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update notifications"})
+	}
+
+	refreshUnreadCount(c, userID, int64(len(changed)))
+	publishReadReceipts(c, changed)
+	return c.JSON(fiber.Map{"updated": len(changed)})
+}
+
+// Mark a batch of notifications as unread
+func MarkBatchAsUnread(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	ids, err := parseNotificationBatch(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result := userNotifications(userID).
+		Where("id IN ? AND status <> ?", ids, "unread").
+		Update("status", "unread")
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update notifications"})
+	}
+
+	refreshUnreadCount(c, userID, result.RowsAffected)
+	return c.JSON(fiber.Map{"updated": result.RowsAffected})
+}
+
+// Archive a batch of notifications, hiding them from the notification list
+// and the unread count
+func ArchiveBatch(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	ids, err := parseNotificationBatch(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result := userNotifications(userID).
+		Where("id IN ? AND archived_at IS NULL", ids).
+		Update("archived_at", time.Now())
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to archive notifications"})
+	}
+
+	refreshUnreadCount(c, userID, result.RowsAffected)
+	return c.JSON(fiber.Map{"archived": result.RowsAffected})
+}
+
+// Delete a batch of notifications
+func DeleteBatch(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	ids, err := parseNotificationBatch(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	result := userNotifications(userID).Where("id IN ?", ids).Delete(&models.Notification{})
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete notifications"})
+	}
+
+	refreshUnreadCount(c, userID, result.RowsAffected)
+	return c.JSON(fiber.Map{"deleted": result.RowsAffected})
+}
@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/delivery"
+	"notification_system/notification_service/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Most delivery targets a user may register
+const maxDeliveryTargets = 20
+
+// deliveryTargetRequest is the body of POST /notifications/delivery-targets
+type deliveryTargetRequest struct {
+	Kind    string `json:"kind"`
+	Address string `json:"address"`
+	// Keys are the p256dh and auth keys of a web push subscription
+	Keys json.RawMessage `json:"keys"`
+}
+
+// List the delivery targets of a user
+func GetDeliveryTargets(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var targets []models.DeliveryTarget
+	if err := config.DB.Where("user_id = ?", userID).Order("id").Find(&targets).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch delivery targets"})
+	}
+
+	return c.JSON(targets)
+}
+
+// Register an address a user receives out-of-band notifications at
+func CreateDeliveryTarget(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var request deliveryTargetRequest
+	if err := c.BodyParser(&request); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+
+	target := models.DeliveryTarget{UserID: userID, Kind: request.Kind, Address: request.Address}
+	switch request.Kind {
+	case delivery.KindWebPush:
+		target.Secret = string(request.Keys)
+	case delivery.KindWebhook:
+		// Webhook requests are signed with a secret only the user learns
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create webhook secret"})
+		}
+		target.Secret = hex.EncodeToString(secret)
+	}
+	if err := delivery.ValidateTarget(&target); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var count int64
+	if err := config.DB.Model(&models.DeliveryTarget{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create delivery target"})
+	}
+	if count >= maxDeliveryTargets {
+		return c.Status(400).JSON(fiber.Map{"error": "Too many delivery targets"})
+	}
+
+	if err := config.DB.Create(&target).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create delivery target"})
+	}
+
+	if target.Kind == delivery.KindWebhook {
+		return c.JSON(fiber.Map{"target": target, "secret": target.Secret})
+	}
+	return c.JSON(fiber.Map{"target": target})
+}
+
+// Remove a delivery target of a user
+func DeleteDeliveryTarget(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	result := config.DB.Where("id = ? AND user_id = ?", c.Params("target_id"), userID).Delete(&models.DeliveryTarget{})
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete delivery target"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Delivery target not found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Delivery target deleted"})
+}
+
+// List the out-of-band deliveries of a notification and their status
+func GetDeliveries(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	notification, err := findUserNotification(c, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notification"})
+	}
+
+	var deliveries []models.Delivery
+	if err := config.DB.Where("notification_id = ?", notification.ID).Order("id").Find(&deliveries).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch deliveries"})
+	}
+
+	return c.JSON(deliveries)
+}
@@ -1,25 +1,36 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"notification_system/notification_service/config"
+	"notification_system/notification_service/events"
 	"notification_system/notification_service/models"
+	"notification_system/notification_service/unread"
+	sharedevents "notification_system/shared/events"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"gorm.io/gorm"
 )
 
 var jwtSecret []byte
 
 func init() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// Load environment variables from .env file, unless the environment
+	// already holds the secret, as in tests
+	if os.Getenv("JWT_SECRET") == "" {
+		if err := godotenv.Load(); err != nil {
+			log.Fatal("Error loading .env file")
+		}
 	}
 
 	// Get the JWT secret from environment variables
@@ -37,8 +48,11 @@ func getUserIDFromToken(c *fiber.Ctx) (uint, error) {
 		return 0, fiber.ErrUnauthorized
 	}
 
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	return parseUserID(strings.TrimPrefix(authHeader, "Bearer "))
+}
 
+// Helper function to retrieve user ID from a JWT token string
+func parseUserID(tokenString string) (uint, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fiber.ErrUnauthorized
@@ -63,37 +77,232 @@ func getUserIDFromToken(c *fiber.Ctx) (uint, error) {
 	return uint(userID), nil
 }
 
-// Get all notifications for a user
+// Helper function to get the correlation ID of a request, generating one when
+// the caller did not send the X-Correlation-ID header
+func getCorrelationID(c *fiber.Ctx) string {
+	if correlationID := c.Get("X-Correlation-ID"); correlationID != "" {
+		return correlationID
+	}
+	return uuid.NewString()
+}
+
+// Get a page of the notifications of a user, newest first unless sort=oldest
 func GetNotifications(c *fiber.Ctx) error {
 	userID, err := getUserIDFromToken(c)
 	if err != nil {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 
+	filter, err := parseNotificationFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	newestFirst := true
+	switch c.Query("sort", "newest") {
+	case "newest":
+	case "oldest":
+		newestFirst = false
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "sort must be newest or oldest"})
+	}
+
+	limit := c.QueryInt("limit", defaultPageSize)
+	if limit < 1 || limit > maxPageSize {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("limit must be between 1 and %d", maxPageSize)})
+	}
+
+	query := filter.apply(userNotifications(userID))
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		query = cursor.after(query, newestFirst)
+	}
+
+	if newestFirst {
+		query = query.Order("created_at DESC, id DESC")
+	} else {
+		query = query.Order("created_at, id")
+	}
+
+	// Fetch one more notification than requested to know whether a next page exists
 	var notifications []models.Notification
-	if err := config.DB.Where("receiver_id = ?", userID).Find(&notifications).Error; err != nil {
+	if err := query.Limit(limit + 1).Find(&notifications).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notifications"})
 	}
 
-	return c.JSON(notifications)
+	nextCursor := ""
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+		nextCursor = encodeCursor(notifications[limit-1])
+	}
+
+	return c.JSON(fiber.Map{
+		"notifications": notifications,
+		"next_cursor":   nextCursor,
+	})
+}
+
+// Get the number of unread notifications of a user
+func GetUnreadCount(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	count, err := unread.Count(c.UserContext(), userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to count unread notifications"})
+	}
+
+	return c.JSON(fiber.Map{"unread_count": count})
+}
+
+// Helper function to scope notification queries to the notifications the
+// caller received, so foreign notifications look like they do not exist
+func userNotifications(userID uint) *gorm.DB {
+	return config.DB.Model(&models.Notification{}).Where("receiver_id = ?", userID)
+}
+
+// Helper function to load a notification of the caller from the route parameters
+func findUserNotification(c *fiber.Ctx, userID uint) (models.Notification, error) {
+	var notification models.Notification
+
+	notificationID, err := strconv.ParseUint(c.Params("notification_id"), 10, 64)
+	if err != nil {
+		return notification, gorm.ErrRecordNotFound
+	}
+
+	err = userNotifications(userID).Where("id = ?", notificationID).First(&notification).Error
+	return notification, err
 }
 
 // Mark a notification as read
 func MarkAsRead(c *fiber.Ctx) error {
-	notificationID := c.Params("notification_id")
-	if notificationID == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "Notification ID is required"})
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 
-	var notification models.Notification
-	if err := config.DB.First(&notification, notificationID).Error; err != nil {
+	notification, err := findUserNotification(c, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
 	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notification"})
+	}
 
+	// Only the request that actually changes the status adjusts the unread count
+	wasUnread := notification.Status == "unread" && notification.ArchivedAt == nil
 	notification.Status = "read"
-	if err := config.DB.Save(&notification).Error; err != nil {
+	result := userNotifications(userID).Where("id = ? AND status <> ?", notification.ID, "read").Update("status", notification.Status)
+	if result.Error != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to update notification"})
 	}
 
+	if wasUnread && result.RowsAffected == 1 {
+		if err := unread.Adjust(c.UserContext(), userID, -1); err != nil {
+			log.Println("Failed to update unread count:", err)
+		}
+	}
+
+	// Only the request that marked the notification read reports it. Read
+	// receipts are best effort; the notification stays read if publishing fails.
+	if result.RowsAffected > 0 {
+		if err := events.PublishReceipt(c.UserContext(), sharedevents.NotificationRead, notification, getCorrelationID(c)); err != nil {
+			log.Println("Failed to publish read receipt:", err)
+		}
+	}
+
 	return c.JSON(fiber.Map{"message": "Notification marked as read"})
 }
+
+// Archive a notification, hiding it from the notification list and the unread count
+func ArchiveNotification(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	notification, err := findUserNotification(c, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notification"})
+	}
+
+	result := userNotifications(userID).Where("id = ? AND archived_at IS NULL", notification.ID).Update("archived_at", time.Now())
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to archive notification"})
+	}
+
+	if notification.Status == "unread" && result.RowsAffected == 1 {
+		if err := unread.Adjust(c.UserContext(), userID, -1); err != nil {
+			log.Println("Failed to update unread count:", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{"message": "Notification archived"})
+}
+
+// Restore an archived notification
+func UnarchiveNotification(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	notification, err := findUserNotification(c, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notification"})
+	}
+
+	result := userNotifications(userID).Where("id = ? AND archived_at IS NOT NULL", notification.ID).Update("archived_at", nil)
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to restore notification"})
+	}
+
+	if notification.Status == "unread" && result.RowsAffected == 1 {
+		if err := unread.Adjust(c.UserContext(), userID, 1); err != nil {
+			log.Println("Failed to update unread count:", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{"message": "Notification restored"})
+}
+
+// Delete a notification. The row is kept until the retention purge removes
+// it, but its receiver no longer sees it.
+func DeleteNotification(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	notification, err := findUserNotification(c, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(404).JSON(fiber.Map{"error": "Notification not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch notification"})
+	}
+
+	result := userNotifications(userID).Where("id = ?", notification.ID).Delete(&models.Notification{})
+	if result.Error != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete notification"})
+	}
+
+	if notification.Status == "unread" && notification.ArchivedAt == nil && result.RowsAffected == 1 {
+		if err := unread.Adjust(c.UserContext(), userID, -1); err != nil {
+			log.Println("Failed to update unread count:", err)
+		}
+	}
+
+	return c.JSON(fiber.Map{"message": "Notification deleted"})
+}
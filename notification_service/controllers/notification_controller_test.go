@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Tests run without a .env file, so init reads the secret from here. Package
+// variables are initialized before init runs.
+var _ = os.Setenv("JWT_SECRET", "test-secret")
+
+const (
+	alice uint = 1
+	bob   uint = 2
+)
+
+// setupNotifications points config.DB at a fresh database and returns an
+// app serving the notification handlers
+func setupNotifications(t *testing.T) *fiber.App {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notifications.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.Notification{}, &models.NotificationPreference{}, &models.QuietHours{},
+		&models.DeferredDelivery{}, &models.DeliveryTarget{}, &models.Delivery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+
+	app := fiber.New()
+	notifications := app.Group("/notifications")
+	notifications.Get("/", GetNotifications)
+	notifications.Get("/:notification_id/deliveries", GetDeliveries)
+	notifications.Put("/:notification_id/read", MarkAsRead)
+	notifications.Put("/:notification_id/archive", ArchiveNotification)
+	notifications.Put("/:notification_id/unarchive", UnarchiveNotification)
+	notifications.Delete("/:notification_id", DeleteNotification)
+	notifications.Post("/read-all", MarkAllAsRead)
+	notifications.Post("/batch/read", MarkBatchAsRead)
+	notifications.Post("/batch/unread", MarkBatchAsUnread)
+	notifications.Post("/batch/archive", ArchiveBatch)
+	notifications.Post("/batch/delete", DeleteBatch)
+	return app
+}
+
+// notify stores an unread notification for receiverID
+func notify(t *testing.T, receiverID uint) models.Notification {
+	t.Helper()
+
+	notification := models.Notification{SenderID: 3, ReceiverID: int(receiverID), Message: "You have received a new friend request", Topic: "friend_request", Status: "unread"}
+	if err := config.DB.Create(&notification).Error; err != nil {
+		t.Fatal(err)
+	}
+	return notification
+}
+
+// call calls a handler as userID and returns the response status and body
+func call(t *testing.T, app *fiber.App, method, path string, userID uint, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userID": userID}).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lists are returned as they are, everything else as an object
+	var response map[string]interface{}
+	if !strings.HasPrefix(string(data), "[") {
+		if err := json.Unmarshal(data, &response); err != nil {
+			t.Fatalf("%s %s returned %q: %v", method, path, data, err)
+		}
+	}
+	return resp.StatusCode, response
+}
+
+// useMemoryBroker makes receipts go to an in-memory broker for the test
+func useMemoryBroker(t *testing.T) *broker.Memory {
+	t.Helper()
+
+	memory := broker.NewMemory()
+	config.KafkaProducer = memory
+	config.NotificationTopic = "notification_events"
+	config.EventFormat = sharedevents.FormatEnvelope
+	t.Cleanup(func() { config.KafkaProducer = nil })
+	return memory
+}
+
+// receipts returns the receipts published to memory
+func receipts(t *testing.T, memory *broker.Memory) []sharedevents.Envelope {
+	t.Helper()
+
+	var envelopes []sharedevents.Envelope
+	memory.Scan(context.Background(), config.NotificationTopic, func(msg *broker.Message) bool {
+		envelope, err := sharedevents.DecodeMessage(msg.Headers, msg.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		envelopes = append(envelopes, envelope)
+		return true
+	})
+	return envelopes
+}
+
+func TestMarkAsReadPublishesOneReceipt(t *testing.T) {
+	app := setupNotifications(t)
+	memory := useMemoryBroker(t)
+
+	notification := notify(t, alice)
+	path := fmt.Sprintf("/notifications/%d/read", notification.ID)
+	for i := 0; i < 2; i++ {
+		if status, _ := call(t, app, "PUT", path, alice, ""); status != 200 {
+			t.Fatalf("read status = %d, want 200", status)
+		}
+	}
+
+	if published := receipts(t, memory); len(published) != 1 || published[0].Type != sharedevents.NotificationRead {
+		t.Errorf("published %+v, want a single notification_read receipt", published)
+	}
+}
+
+func TestBulkReadsPublishReceipts(t *testing.T) {
+	app := setupNotifications(t)
+	memory := useMemoryBroker(t)
+	first, second, third := notify(t, alice), notify(t, alice), notify(t, alice)
+	notify(t, bob)
+
+	requests := []struct{ path, body string }{
+		{"/notifications/batch/read", fmt.Sprintf(`{"ids": [%d, %d]}`, first.ID, second.ID)},
+		{"/notifications/read-all", ""},
+		// Nothing is left to change
+		{"/notifications/batch/read", fmt.Sprintf(`{"ids": [%d]}`, first.ID)},
+		{"/notifications/read-all", ""},
+	}
+	for _, request := range requests {
+		if status, response := call(t, app, "POST", request.path, alice, request.body); status != 200 {
+			t.Fatalf("POST %s = %d %v, want 200", request.path, status, response)
+		}
+	}
+
+	// One receipt for each notification alice read
+	var read []int64
+	for _, envelope := range receipts(t, memory) {
+		var receipt sharedevents.NotificationReceipt
+		if err := json.Unmarshal(envelope.Payload, &receipt); err != nil {
+			t.Fatal(err)
+		}
+		if envelope.Type != sharedevents.NotificationRead || receipt.ReceiverID != int(alice) {
+			t.Errorf("published %+v, want read receipts of alice", envelope)
+		}
+		read = append(read, receipt.NotificationID)
+	}
+	if want := []int64{int64(first.ID), int64(second.ID), int64(third.ID)}; !slices.Equal(read, want) {
+		t.Errorf("published receipts for %v, want %v", read, want)
+	}
+}
+
+// unchanged fails the test if any of notifications was modified since it
+// was stored
+func unchanged(t *testing.T, notifications ...models.Notification) {
+	t.Helper()
+
+	for _, notification := range notifications {
+		var stored models.Notification
+		if err := config.DB.First(&stored, notification.ID).Error; err != nil {
+			t.Errorf("notification %d: %v", notification.ID, err)
+			continue
+		}
+		if stored.Status != notification.Status || stored.ArchivedAt != nil {
+			t.Errorf("notification %d was changed to %+v", notification.ID, stored)
+		}
+	}
+}
+
+func TestForeignNotificationsAreNotFound(t *testing.T) {
+	app := setupNotifications(t)
+	notification := notify(t, alice)
+
+	for _, request := range []struct{ method, path string }{
+		{"GET", "/notifications/%d/deliveries"},
+		{"PUT", "/notifications/%d/read"},
+		{"PUT", "/notifications/%d/archive"},
+		{"PUT", "/notifications/%d/unarchive"},
+		{"DELETE", "/notifications/%d"},
+	} {
+		path := fmt.Sprintf(request.path, notification.ID)
+		if status, response := call(t, app, request.method, path, bob, ""); status != 404 {
+			t.Errorf("bob %s %s = %d %v, want 404", request.method, path, status, response)
+		}
+	}
+
+	status, response := call(t, app, "GET", "/notifications/", bob, "")
+	if list, _ := response["notifications"].([]interface{}); status != 200 || len(list) != 0 {
+		t.Errorf("bob listed %d %v, want no notifications", status, response)
+	}
+
+	unchanged(t, notification)
+
+	// The receiver still reaches it
+	path := fmt.Sprintf("/notifications/%d/deliveries", notification.ID)
+	if status, response := call(t, app, "GET", path, alice, ""); status != 200 {
+		t.Errorf("alice GET %s = %d %v, want 200", path, status, response)
+	}
+}
+
+func TestBatchesSkipForeignNotifications(t *testing.T) {
+	app := setupNotifications(t)
+	first, second := notify(t, alice), notify(t, alice)
+	own := notify(t, bob)
+	body := fmt.Sprintf(`{"ids": [%d, %d, %d]}`, first.ID, second.ID, own.ID)
+
+	// Each batch only changes bob's own notification
+	for _, request := range []struct{ path, count string }{
+		{"/notifications/batch/read", "updated"},
+		{"/notifications/batch/unread", "updated"},
+		{"/notifications/batch/archive", "archived"},
+		{"/notifications/batch/delete", "deleted"},
+	} {
+		status, response := call(t, app, "POST", request.path, bob, body)
+		if status != 200 || response[request.count] != float64(1) {
+			t.Errorf("bob POST %s = %d %v, want 1 %s", request.path, status, response, request.count)
+		}
+	}
+
+	status, response := call(t, app, "POST", "/notifications/read-all", bob, "")
+	if status != 200 || response["updated"] != float64(0) {
+		t.Errorf("bob POST /notifications/read-all = %d %v, want 0 updated", status, response)
+	}
+
+	unchanged(t, first, second)
+}
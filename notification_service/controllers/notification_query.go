@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification_system/notification_service/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Page sizes of GET /notifications
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// notificationFilter narrows the caller's notifications by the query
+// parameters status, topic, sender_id, from, to and archived
+type notificationFilter struct {
+	Status   string
+	Topic    string
+	SenderID int
+	From     time.Time
+	To       time.Time
+	// Archived selects archived notifications instead of the others
+	Archived bool
+}
+
+// Helper function to parse the notification filter from the query parameters
+func parseNotificationFilter(c *fiber.Ctx) (notificationFilter, error) {
+	filter := notificationFilter{
+		Status: c.Query("status"),
+		Topic:  c.Query("topic"),
+	}
+
+	if filter.Status != "" && filter.Status != "read" && filter.Status != "unread" {
+		return filter, errors.New("status must be read or unread")
+	}
+
+	if value := c.Query("sender_id"); value != "" {
+		senderID, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, errors.New("sender_id must be a number")
+		}
+		filter.SenderID = senderID
+	}
+
+	if value := c.Query("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, errors.New("archived must be true or false")
+		}
+		filter.Archived = archived
+	}
+
+	for _, bound := range []struct {
+		name string
+		time *time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := c.Query(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", bound.name)
+		}
+		*bound.time = t
+	}
+
+	return filter, nil
+}
+
+// apply adds the filter's conditions to a query; from is inclusive and to exclusive
+func (f notificationFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	if f.Topic != "" {
+		query = query.Where("topic = ?", f.Topic)
+	}
+	if f.SenderID != 0 {
+		query = query.Where("sender_id = ?", f.SenderID)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("created_at < ?", f.To)
+	}
+	if f.Archived {
+		query = query.Where("archived_at IS NOT NULL")
+	} else {
+		query = query.Where("archived_at IS NULL")
+	}
+	return query
+}
+
+// notificationCursor is the position after the last notification of a page.
+// Notifications are ordered by creation time, and by ID among those created
+// at the same time.
+type notificationCursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+// Helper function to encode the cursor of the page following a notification
+func encodeCursor(notification models.Notification) string {
+	position := fmt.Sprintf("%d:%d", notification.CreatedAt.UnixNano(), notification.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+// Helper function to decode a cursor returned by encodeCursor
+func decodeCursor(value string) (notificationCursor, error) {
+	var cursor notificationCursor
+
+	position, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+
+	createdAt, id, ok := strings.Cut(string(position), ":")
+	nanos, err := strconv.ParseInt(createdAt, 10, 64)
+	if !ok || err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+	notificationID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return cursor, errors.New("invalid cursor")
+	}
+
+	cursor.CreatedAt = time.Unix(0, nanos).UTC()
+	cursor.ID = uint(notificationID)
+	return cursor, nil
+}
+
+// after restricts a query to the notifications following the cursor
+func (c notificationCursor) after(query *gorm.DB, newestFirst bool) *gorm.DB {
+	if newestFirst {
+		return query.Where("(created_at < ? OR (created_at = ? AND id < ?))", c.CreatedAt, c.CreatedAt, c.ID)
+	}
+	return query.Where("(created_at > ? OR (created_at = ? AND id > ?))", c.CreatedAt, c.CreatedAt, c.ID)
+}
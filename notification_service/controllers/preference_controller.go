@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"fmt"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/preferences"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+)
+
+// preferenceUpdate is one entry of the body of PUT /notifications/preferences
+type preferenceUpdate struct {
+	Topic   string `json:"topic"`
+	Channel string `json:"channel"`
+	Enabled *bool  `json:"enabled"`
+}
+
+// Helper function to check that a preference names a known topic and channel
+func validatePreference(topic, channel string) error {
+	if !preferences.ValidTopic(topic) {
+		return fmt.Errorf("unknown topic %q", topic)
+	}
+	if !preferences.ValidChannel(channel) {
+		return fmt.Errorf("unknown channel %q", channel)
+	}
+	return nil
+}
+
+// Helper function to save preferences of a user, replacing earlier choices
+// for the same topics and channels
+func savePreferences(userID uint, updates []preferenceUpdate) error {
+	rows := make([]models.NotificationPreference, len(updates))
+	for i, update := range updates {
+		rows[i] = models.NotificationPreference{
+			UserID:  userID,
+			Topic:   update.Topic,
+			Channel: update.Channel,
+			Enabled: *update.Enabled,
+		}
+	}
+
+	return config.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "topic"}, {Name: "channel"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled", "updated_at"}),
+	}).Create(&rows).Error
+}
+
+// Get the preferences of a user for every topic and channel
+func GetPreferences(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	userPreferences, err := preferences.ForUser(config.DB, userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch preferences"})
+	}
+
+	return c.JSON(userPreferences)
+}
+
+// Update several preferences of a user at once
+func UpdatePreferences(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var updates []preferenceUpdate
+	if err := c.BodyParser(&updates); err != nil || len(updates) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Request body must be a list of preferences"})
+	}
+
+	for _, update := range updates {
+		if err := validatePreference(update.Topic, update.Channel); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if update.Enabled == nil {
+			return c.Status(400).JSON(fiber.Map{"error": "enabled must be set for every preference"})
+		}
+	}
+
+	if err := savePreferences(userID, updates); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update preferences"})
+	}
+
+	return GetPreferences(c)
+}
+
+// Enable or disable a channel for a topic
+func SetPreference(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	update := preferenceUpdate{Topic: c.Params("topic"), Channel: c.Params("channel")}
+	if err := validatePreference(update.Topic, update.Channel); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := c.BodyParser(&update); err != nil || update.Enabled == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Request body must set enabled"})
+	}
+	update.Topic, update.Channel = c.Params("topic"), c.Params("channel")
+
+	if err := savePreferences(userID, []preferenceUpdate{update}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update preference"})
+	}
+
+	return c.JSON(preferences.Preference{Topic: update.Topic, Channel: update.Channel, Enabled: *update.Enabled})
+}
+
+// Reset a channel of a topic to its default
+func ResetPreference(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	topic, channel := c.Params("topic"), c.Params("channel")
+	if err := validatePreference(topic, channel); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = config.DB.Where("user_id = ? AND topic = ? AND channel = ?", userID, topic, channel).
+		Delete(&models.NotificationPreference{}).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reset preference"})
+	}
+
+	return c.JSON(preferences.Preference{
+		Topic:   topic,
+		Channel: channel,
+		Enabled: preferences.Default(topic, channel),
+		Default: true,
+	})
+}
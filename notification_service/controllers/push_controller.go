@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/push"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Number of missed notifications loaded at a time when a client resumes
+const resumeBatchSize = 100
+
+// Time allowed to write a message to a push connection
+const pushWriteTimeout = 10 * time.Second
+
+// Push connections hold their slot of the connection limit for this many
+// heartbeats at a time, so a slot outlives a late heartbeat but not a crash
+const pushLeaseHeartbeats = 3
+
+// Helper function to retrieve the user ID of a push connection. Browsers
+// cannot set headers on WebSocket and EventSource requests, so the JWT may
+// also be sent in the token query parameter.
+func getStreamUserID(c *fiber.Ctx) (uint, error) {
+	if c.Get("Authorization") == "" && c.Query("token") != "" {
+		return parseUserID(c.Query("token"))
+	}
+	return getUserIDFromToken(c)
+}
+
+// Helper function to visit the notification events of a user stored after lastID, oldest first
+func missedNotifications(userID uint, lastID uint, visit func(push.Event) error) error {
+	for {
+		var notifications []models.Notification
+		err := userNotifications(userID).Where("id > ?", lastID).Order("id").Limit(resumeBatchSize).Find(&notifications).Error
+		if err != nil {
+			return err
+		}
+
+		for _, notification := range notifications {
+			event, err := push.NotificationEvent(notification)
+			if err != nil {
+				return err
+			}
+			if err := visit(event); err != nil {
+				return err
+			}
+			lastID = notification.ID
+		}
+
+		if len(notifications) < resumeBatchSize {
+			return nil
+		}
+	}
+}
+
+// Authenticate a WebSocket upgrade request before it reaches PushNotifications
+func UpgradePush(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(426).JSON(fiber.Map{"error": "WebSocket upgrade required"})
+	}
+
+	userID, err := getStreamUserID(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	lastID, err := strconv.ParseUint(c.Query("last_id", "0"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid last_id"})
+	}
+
+	if limit := config.PushMaxConnections; limit > 0 && push.Connections(c.UserContext(), userID) >= limit {
+		return c.Status(429).JSON(fiber.Map{"error": "Too many push connections"})
+	}
+
+	c.Locals("user_id", userID)
+	c.Locals("last_id", uint(lastID))
+	return c.Next()
+}
+
+// pushFrame is the JSON message written to push WebSockets for each event
+type pushFrame struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Push the caller's notifications over a WebSocket as they are stored,
+// starting with those stored after last_id, and other events such as
+// unread count changes
+var PushNotifications = websocket.New(func(conn *websocket.Conn) {
+	userID := conn.Locals("user_id").(uint)
+	lastID := conn.Locals("last_id").(uint)
+
+	// Subscribe before loading missed notifications, so none stored in
+	// between is lost; notifications seen twice are skipped by ID
+	subscription, err := push.Connect(context.Background(), userID, config.PushMaxConnections, pushLeaseHeartbeats*config.PushHeartbeat)
+	if err != nil {
+		// The limit was reached by connections opened since the upgrade
+		if errors.Is(err, push.ErrTooManyConnections) {
+			closePush(conn, websocket.ClosePolicyViolation, "too many push connections")
+		} else {
+			closePush(conn, websocket.CloseGoingAway, "server shutting down")
+		}
+		return
+	}
+	defer push.Disconnect(context.Background(), subscription)
+
+	// Read until the client goes away, handling pongs and close frames
+	disconnected := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * config.PushHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * config.PushHeartbeat))
+	})
+	go func() {
+		defer close(disconnected)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event push.Event) error {
+		if event.Type == push.EventNotification && event.ID <= lastID {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(pushWriteTimeout))
+		if err := conn.WriteJSON(pushFrame{Type: event.Type, Data: event.Data}); err != nil {
+			return err
+		}
+		if event.ID > lastID {
+			lastID = event.ID
+		}
+		return nil
+	}
+
+	if lastID > 0 {
+		if err := missedNotifications(userID, lastID, send); err != nil {
+			log.Println("Failed to resume push connection:", err)
+			closePush(conn, websocket.CloseInternalServerErr, "failed to load missed notifications")
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(config.PushHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-subscription.C:
+			if !ok {
+				if errors.Is(subscription.Err(), push.ErrHubClosed) {
+					closePush(conn, websocket.CloseGoingAway, "server shutting down")
+				} else {
+					closePush(conn, websocket.CloseTryAgainLater, "too slow, resume from last_id")
+				}
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pushWriteTimeout)); err != nil {
+				return
+			}
+			push.KeepAlive(context.Background(), subscription)
+		case <-disconnected:
+			return
+		}
+	}
+})
+
+// Helper function to close a push connection with a close frame
+func closePush(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(pushWriteTimeout))
+}
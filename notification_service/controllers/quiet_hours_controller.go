@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"log"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/schedule"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+)
+
+// Helper function to move a user's deferred deliveries after their quiet
+// hours changed. A failure only delays deliveries until the old quiet hours end.
+func rescheduleDeliveries(userID uint) {
+	if err := schedule.Reschedule(config.DB, userID, time.Now()); err != nil {
+		log.Println("Failed to reschedule deferred deliveries:", err)
+	}
+}
+
+// Get the quiet hours of a user
+func GetQuietHours(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var quietHours models.QuietHours
+	if err := config.DB.Where("user_id = ?", userID).Limit(1).Find(&quietHours).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch quiet hours"})
+	}
+	if quietHours.UserID == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Quiet hours not set"})
+	}
+
+	return c.JSON(quietHours)
+}
+
+// Set the quiet hours of a user
+func SetQuietHours(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var quietHours models.QuietHours
+	if err := c.BodyParser(&quietHours); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request body"})
+	}
+	if _, err := schedule.ParseWindow(quietHours); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	quietHours.UserID = userID
+
+	err = config.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"start", "end", "timezone", "updated_at"}),
+	}).Create(&quietHours).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update quiet hours"})
+	}
+
+	rescheduleDeliveries(userID)
+	return c.JSON(quietHours)
+}
+
+// Remove the quiet hours of a user, releasing their deferred deliveries
+func DeleteQuietHours(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	if err := config.DB.Where("user_id = ?", userID).Delete(&models.QuietHours{}).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to delete quiet hours"})
+	}
+
+	rescheduleDeliveries(userID)
+	return c.JSON(fiber.Map{"message": "Quiet hours removed"})
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/push"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Delay browsers wait before reconnecting a closed stream
+const streamRetry = 3 * time.Second
+
+// Stream the caller's notifications as Server-Sent Events as they are stored,
+// along with other events such as unread count changes. A reconnecting
+// client sends the ID of the last notification it received in the
+// Last-Event-ID header, or the last_event_id query parameter, and first
+// receives the notifications stored since.
+func StreamNotifications(c *fiber.Ctx) error {
+	userID, err := getStreamUserID(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	lastEventID := c.Get("Last-Event-ID", c.Query("last_event_id", "0"))
+	lastID, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid Last-Event-ID"})
+	}
+
+	// Subscribe before loading missed notifications, so none stored in
+	// between is lost; notifications seen twice are skipped by ID
+	subscription, err := push.Connect(c.UserContext(), userID, config.PushMaxConnections, pushLeaseHeartbeats*config.PushHeartbeat)
+	if errors.Is(err, push.ErrTooManyConnections) {
+		return c.Status(429).JSON(fiber.Map{"error": "Too many push connections"})
+	}
+	if err != nil {
+		return c.Status(503).JSON(fiber.Map{"error": "Server shutting down"})
+	}
+
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	// Ask proxies such as nginx not to buffer the stream
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer push.Disconnect(context.Background(), subscription)
+		streamNotifications(w, subscription, uint(lastID))
+	})
+	return nil
+}
+
+// Helper function to write a subscription's events to an event
+// stream until the client disconnects or the subscription ends
+func streamNotifications(w *bufio.Writer, subscription *push.Subscription, lastID uint) {
+	send := func(event push.Event) error {
+		if event.Type == push.EventNotification {
+			if event.ID <= lastID {
+				return nil
+			}
+			// Browsers send the last id they received in Last-Event-ID
+			fmt.Fprintf(w, "id: %d\n", event.ID)
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if event.ID > lastID {
+			lastID = event.ID
+		}
+		return nil
+	}
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	if err := w.Flush(); err != nil {
+		return
+	}
+
+	if lastID > 0 {
+		if err := missedNotifications(subscription.UserID, lastID, send); err != nil {
+			log.Println("Failed to resume notification stream:", err)
+			return
+		}
+	}
+
+	// Keep-alive comments stop proxies from closing an idle stream and
+	// reveal clients that went away
+	keepAlive := time.NewTicker(config.PushHeartbeat)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event, ok := <-subscription.C:
+			// The client reconnects with Last-Event-ID when the stream ends
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			if err := w.Flush(); err != nil {
+				return
+			}
+			push.KeepAlive(context.Background(), subscription)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package delivery sends notifications over out-of-band channels: email,
+// web push, mobile push and webhooks. Every delivery to a target is recorded
+// in the deliveries table and retried with backoff until it was sent or
+// failed for good.
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/preferences"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+// Kinds of delivery targets, each sent to by one Channel
+const (
+	KindEmail   = "email"
+	KindWebPush = "webpush"
+	KindFCM     = "fcm"
+	KindAPNs    = "apns"
+	KindWebhook = "webhook"
+)
+
+// kindChannels maps every kind to the preference channel it belongs to
+var kindChannels = map[string]string{
+	KindEmail:   preferences.ChannelEmail,
+	KindWebPush: preferences.ChannelPush,
+	KindFCM:     preferences.ChannelPush,
+	KindAPNs:    preferences.ChannelPush,
+	KindWebhook: preferences.ChannelWebhook,
+}
+
+var (
+	// ErrPermanent marks failures that retrying cannot fix, such as a
+	// rejected address
+	ErrPermanent = errors.New("permanent delivery failure")
+	// ErrTargetGone marks targets that no longer exist, such as expired web
+	// push subscriptions or unregistered devices. They are removed.
+	ErrTargetGone = fmt.Errorf("%w: target no longer exists", ErrPermanent)
+)
+
+// Message is one delivery of a notification to a target
+type Message struct {
+	DeliveryID   uint
+	Notification models.Notification
+	Target       models.DeliveryTarget
+}
+
+// Title returns the title shown with the notification, derived from its topic
+func (m Message) Title() string {
+	title := strings.ReplaceAll(m.Notification.Topic, "_", " ")
+	if title == "" {
+		return "New notification"
+	}
+	return strings.ToUpper(title[:1]) + title[1:]
+}
+
+// Channel sends notifications to delivery targets of one kind
+type Channel interface {
+	Kind() string
+	// Send delivers a message. Errors wrapping ErrPermanent are not retried.
+	Send(ctx context.Context, message Message) error
+}
+
+// ValidateTarget checks a target a user registers and sets its channel
+func ValidateTarget(target *models.DeliveryTarget) error {
+	channel, ok := kindChannels[target.Kind]
+	if !ok {
+		return fmt.Errorf("unknown kind %q", target.Kind)
+	}
+	target.Channel = channel
+
+	switch target.Kind {
+	case KindEmail:
+		address, err := mail.ParseAddress(target.Address)
+		if err != nil {
+			return fmt.Errorf("invalid email address %q", target.Address)
+		}
+		target.Address = address.Address
+	case KindWebPush, KindWebhook:
+		endpoint, err := url.Parse(target.Address)
+		if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+			return fmt.Errorf("invalid URL %q", target.Address)
+		}
+		if target.Kind == KindWebPush {
+			var keys webpush.Keys
+			if err := json.Unmarshal([]byte(target.Secret), &keys); err != nil || keys.Auth == "" || keys.P256dh == "" {
+				return errors.New("web push subscriptions need their p256dh and auth keys")
+			}
+		}
+	case KindFCM, KindAPNs:
+		if target.Address == "" || strings.ContainsAny(target.Address, "/?# ") {
+			return fmt.Errorf("invalid device token %q", target.Address)
+		}
+	}
+
+	if len(target.Address) > 2048 {
+		return errors.New("address must not be longer than 2048 characters")
+	}
+	return nil
+}
+
+// Helper function to turn an HTTP response into a delivery error. Client
+// errors other than timeouts and rate limits are permanent, and gone marks
+// the statuses meaning the target no longer exists.
+func checkResponse(resp *http.Response, gone ...int) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err := fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	for _, status := range gone {
+		if resp.StatusCode == status {
+			return fmt.Errorf("%w: %v", ErrTargetGone, err)
+		}
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	return err
+}
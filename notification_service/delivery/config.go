@@ -0,0 +1,161 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"notification_system/notification_service/events"
+)
+
+// DefaultTimeout limits each attempt to send a notification
+const DefaultTimeout = 10 * time.Second
+
+// ChannelsFromEnv creates a Channel for every configured adapter:
+//
+//   - email when SMTP_ADDR is set, sending from SMTP_FROM, logging in with
+//     SMTP_USERNAME and SMTP_PASSWORD when set
+//   - webpush when VAPID_PUBLIC_KEY and VAPID_PRIVATE_KEY are set, with
+//     VAPID_SUBJECT as contact
+//   - fcm when FCM_URL is set, authorized with FCM_ACCESS_TOKEN
+//   - apns when APNS_KEY_FILE is set, signing tokens with APNS_KEY_ID and
+//     APNS_TEAM_ID for the app APNS_TOPIC, sent to APNS_URL
+//   - webhook always
+//
+// Each attempt is limited to DELIVERY_TIMEOUT. Web push endpoints and
+// webhook URLs are given by users, so private network addresses are refused
+// unless DELIVERY_ALLOW_PRIVATE_NETWORKS is true.
+func ChannelsFromEnv() (map[string]Channel, error) {
+	timeout := DefaultTimeout
+	if value := os.Getenv("DELIVERY_TIMEOUT"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+			return nil, errors.New("DELIVERY_TIMEOUT must be a positive duration")
+		}
+	}
+
+	allowPrivate := os.Getenv("DELIVERY_ALLOW_PRIVATE_NETWORKS") == "true"
+	client := &http.Client{Timeout: timeout}
+	userClient := &http.Client{Timeout: timeout, Transport: publicTransport(allowPrivate)}
+	channels := make(map[string]Channel)
+
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			return nil, errors.New("SMTP_FROM must be set when SMTP_ADDR is")
+		}
+		channels[KindEmail] = &EmailChannel{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			Timeout:  timeout,
+		}
+	}
+
+	if publicKey, privateKey := os.Getenv("VAPID_PUBLIC_KEY"), os.Getenv("VAPID_PRIVATE_KEY"); publicKey != "" && privateKey != "" {
+		channels[KindWebPush] = &WebPushChannel{
+			PublicKey:  publicKey,
+			PrivateKey: privateKey,
+			Subject:    os.Getenv("VAPID_SUBJECT"),
+			Client:     userClient,
+		}
+	}
+
+	if endpoint := os.Getenv("FCM_URL"); endpoint != "" {
+		channels[KindFCM] = &FCMChannel{
+			URL:         endpoint,
+			AccessToken: os.Getenv("FCM_ACCESS_TOKEN"),
+			Client:      client,
+		}
+	}
+
+	if keyFile := os.Getenv("APNS_KEY_FILE"); keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read APNS_KEY_FILE: %w", err)
+		}
+		apns, err := NewAPNsChannel(os.Getenv("APNS_URL"), key, os.Getenv("APNS_KEY_ID"), os.Getenv("APNS_TEAM_ID"), os.Getenv("APNS_TOPIC"), client)
+		if err != nil {
+			return nil, err
+		}
+		channels[KindAPNs] = apns
+	}
+
+	channels[KindWebhook] = &WebhookChannel{Client: userClient}
+	return channels, nil
+}
+
+// DefaultRetryPolicy is used for settings missing from the environment
+var DefaultRetryPolicy = events.RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 30 * time.Second,
+	MaxBackoff:     time.Hour,
+}
+
+// RetryPolicyFromEnv reads the retry policy of deliveries from
+// DELIVERY_MAX_ATTEMPTS, DELIVERY_INITIAL_BACKOFF and DELIVERY_MAX_BACKOFF
+func RetryPolicyFromEnv() events.RetryPolicy {
+	policy := DefaultRetryPolicy
+
+	if value := os.Getenv("DELIVERY_MAX_ATTEMPTS"); value != "" {
+		if attempts, err := strconv.Atoi(value); err == nil && attempts > 0 {
+			policy.MaxAttempts = attempts
+		} else {
+			log.Println("Ignoring invalid DELIVERY_MAX_ATTEMPTS:", value)
+		}
+	}
+
+	if value := os.Getenv("DELIVERY_INITIAL_BACKOFF"); value != "" {
+		if backoff, err := time.ParseDuration(value); err == nil && backoff > 0 {
+			policy.InitialBackoff = backoff
+		} else {
+			log.Println("Ignoring invalid DELIVERY_INITIAL_BACKOFF:", value)
+		}
+	}
+
+	if value := os.Getenv("DELIVERY_MAX_BACKOFF"); value != "" {
+		if backoff, err := time.ParseDuration(value); err == nil && backoff > 0 {
+			policy.MaxBackoff = backoff
+		} else {
+			log.Println("Ignoring invalid DELIVERY_MAX_BACKOFF:", value)
+		}
+	}
+
+	return policy
+}
+
+// publicTransport returns a transport that refuses to connect to loopback,
+// private and link-local addresses unless allowPrivate is set. The address
+// is checked after name resolution, so DNS cannot point it elsewhere.
+func publicTransport(allowPrivate bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if allowPrivate {
+		return transport
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return fmt.Errorf("%w: refusing to connect to private address %s", ErrPermanent, host)
+			}
+			return nil
+		},
+	}
+	transport.DialContext = dialer.DialContext
+	return transport
+}
@@ -0,0 +1,250 @@
+package delivery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"notification_system/notification_service/delivery/fakedelivery"
+	"notification_system/notification_service/models"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// The adapters are tested against the same fakes cmd/fakedelivery serves
+
+// fake serves the fakes for the duration of a test
+type fake struct {
+	*fakedelivery.Server
+	http     *httptest.Server
+	smtpAddr string
+}
+
+func startFake(t *testing.T) *fake {
+	t.Helper()
+
+	server := fakedelivery.NewServer()
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.ServeSMTP(listener)
+
+	return &fake{Server: server, http: httpServer, smtpAddr: listener.Addr().String()}
+}
+
+// only returns the single message the fakes received
+func (f *fake) only(t *testing.T) fakedelivery.Received {
+	t.Helper()
+
+	received := f.Received()
+	if len(received) != 1 {
+		t.Fatalf("fakes received %+v, want one message", received)
+	}
+	return received[0]
+}
+
+var sampleNotification = models.Notification{ID: 42, SenderID: 1, ReceiverID: 2, Message: "You have received a new friend request", Topic: "friend_request", Status: "unread"}
+
+func TestEmailChannel(t *testing.T) {
+	fake := startFake(t)
+	channel := &EmailChannel{Addr: fake.smtpAddr, From: "notifications@example.com", Timeout: 5 * time.Second}
+
+	target := models.DeliveryTarget{Kind: KindEmail, Address: "bob@example.com"}
+	if err := channel.Send(context.Background(), Message{DeliveryID: 1, Notification: sampleNotification, Target: target}); err != nil {
+		t.Fatal(err)
+	}
+
+	email := fake.only(t)
+	if email.Kind != "email" || email.Target != "bob@example.com" || !strings.Contains(email.Body, "Subject: Friend request") ||
+		!strings.Contains(email.Body, sampleNotification.Message) {
+		t.Errorf("received %+v, want the notification mailed to bob", email)
+	}
+}
+
+func TestWebPushChannel(t *testing.T) {
+	fake := startFake(t)
+	privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := &WebPushChannel{PublicKey: publicKey, PrivateKey: privateKey, Subject: "mailto:admin@example.com", Client: fake.http.Client()}
+
+	// Subscribe as a browser would
+	resp, err := http.Post(fake.http.URL+"/webpush/subscriptions", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var subscription struct {
+		Endpoint string          `json:"endpoint"`
+		Keys     json.RawMessage `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&subscription); err != nil {
+		t.Fatal(err)
+	}
+
+	target := models.DeliveryTarget{Kind: KindWebPush, Address: subscription.Endpoint, Secret: string(subscription.Keys)}
+	if err := channel.Send(context.Background(), Message{DeliveryID: 1, Notification: sampleNotification, Target: target}); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload pushPayload
+	push := fake.only(t)
+	if err := json.Unmarshal([]byte(push.Body), &payload); err != nil {
+		t.Fatalf("web push body %q: %v", push.Body, err)
+	}
+	if payload != newPushPayload(Message{Notification: sampleNotification}) {
+		t.Errorf("decrypted %+v, want the notification", payload)
+	}
+
+	// Expired subscriptions are gone
+	target.Address += "?status=410"
+	if err := channel.Send(context.Background(), Message{DeliveryID: 2, Notification: sampleNotification, Target: target}); !errors.Is(err, ErrTargetGone) {
+		t.Errorf("sending to an expired subscription = %v, want ErrTargetGone", err)
+	}
+}
+
+func TestFCMChannel(t *testing.T) {
+	fake := startFake(t)
+	channel := &FCMChannel{URL: fake.http.URL + "/fcm/v1/projects/fake/messages:send", AccessToken: "access-token", Client: fake.http.Client()}
+
+	target := models.DeliveryTarget{Kind: KindFCM, Address: "device-token"}
+	if err := channel.Send(context.Background(), Message{DeliveryID: 1, Notification: sampleNotification, Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	message := fake.only(t)
+	if message.Kind != "fcm" || message.Target != "device-token" || message.Headers["Authorization"] != "Bearer access-token" {
+		t.Errorf("received %+v, want an authorized message to device-token", message)
+	}
+
+	target.Address = "unregistered"
+	if err := channel.Send(context.Background(), Message{DeliveryID: 2, Notification: sampleNotification, Target: target}); !errors.Is(err, ErrTargetGone) {
+		t.Errorf("sending to an unregistered device = %v, want ErrTargetGone", err)
+	}
+}
+
+func TestAPNsChannel(t *testing.T) {
+	fake := startFake(t)
+
+	// Any P-256 key in PKCS #8 form stands in for a .p8 signing key
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	channel, err := NewAPNsChannel(fake.http.URL+"/apns", keyPEM, "KEYID", "TEAMID", "com.example.app", fake.http.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := models.DeliveryTarget{Kind: KindAPNs, Address: "device-token"}
+	if err := channel.Send(context.Background(), Message{DeliveryID: 1, Notification: sampleNotification, Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	message := fake.only(t)
+	if message.Kind != "apns" || message.Target != "device-token" || message.Headers["apns-topic"] != "com.example.app" {
+		t.Errorf("received %+v, want an alert for com.example.app", message)
+	}
+
+	target.Address = "unregistered"
+	if err := channel.Send(context.Background(), Message{DeliveryID: 2, Notification: sampleNotification, Target: target}); !errors.Is(err, ErrTargetGone) {
+		t.Errorf("sending to an unregistered device = %v, want ErrTargetGone", err)
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	fake := startFake(t)
+	channel := &WebhookChannel{Client: fake.http.Client()}
+
+	target := models.DeliveryTarget{Kind: KindWebhook, Address: fake.http.URL + "/webhook", Secret: "webhook-secret"}
+	if err := channel.Send(context.Background(), Message{DeliveryID: 7, Notification: sampleNotification, Target: target}); err != nil {
+		t.Fatal(err)
+	}
+	hook := fake.only(t)
+	signature := "sha256=" + SignWebhook("webhook-secret", hook.Headers["X-Webhook-Timestamp"], []byte(hook.Body))
+	if hook.Headers["X-Webhook-ID"] != "7" || hook.Headers["X-Webhook-Signature"] != signature {
+		t.Errorf("received %+v, want delivery 7 signed with the target's secret", hook)
+	}
+
+	// Server errors are retried, client errors are not
+	target.Address = fake.http.URL + "/webhook?status=503"
+	if err := channel.Send(context.Background(), Message{DeliveryID: 8, Notification: sampleNotification, Target: target}); err == nil || errors.Is(err, ErrPermanent) {
+		t.Errorf("sending to a failing webhook = %v, want a retryable error", err)
+	}
+	target.Address = fake.http.URL + "/webhook?status=400"
+	if err := channel.Send(context.Background(), Message{DeliveryID: 9, Notification: sampleNotification, Target: target}); !errors.Is(err, ErrPermanent) {
+		t.Errorf("sending to a rejecting webhook = %v, want ErrPermanent", err)
+	}
+}
+
+func TestDispatchQueuesEachTargetOnce(t *testing.T) {
+	fake := startFake(t)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notifications.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Notification{}, &models.DeliveryTarget{}, &models.Delivery{}); err != nil {
+		t.Fatal(err)
+	}
+	notification := sampleNotification
+	target := models.DeliveryTarget{UserID: 2, Channel: "webhook", Kind: KindWebhook, Address: fake.http.URL + "/webhook", Secret: "webhook-secret"}
+	if err := db.Create(&notification).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&target).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	dispatcher := NewDispatcher(db, map[string]Channel{KindWebhook: &WebhookChannel{Client: fake.http.Client()}}, DefaultRetryPolicy)
+	ctx := context.Background()
+
+	// A retried event dispatches its notification again, before and after
+	// the first delivery was sent
+	for i := 0; i < 2; i++ {
+		if err := dispatcher.Dispatch(ctx, notification, "webhook"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent, err := dispatcher.Process(ctx); err != nil || sent != 1 {
+		t.Fatalf("Process = %d, %v, want 1 delivery sent", sent, err)
+	}
+	if err := dispatcher.Dispatch(ctx, notification, "webhook"); err != nil {
+		t.Fatal(err)
+	}
+	if sent, err := dispatcher.Process(ctx); err != nil || sent != 0 {
+		t.Errorf("Process after dispatching again = %d, %v, want nothing sent", sent, err)
+	}
+
+	var deliveries []models.Delivery
+	db.Find(&deliveries)
+	if len(deliveries) != 1 || deliveries[0].Status != models.DeliverySent {
+		t.Errorf("deliveries = %+v, want one sent delivery", deliveries)
+	}
+	fake.only(t)
+}
@@ -0,0 +1,259 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"notification_system/notification_service/events"
+	"notification_system/notification_service/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Most deliveries claimed and sent at once
+const batchSize = 50
+
+// A claimed delivery is not claimed again for this long, so deliveries of a
+// replica that stopped while sending them are sent by another one afterwards
+const claimLease = 5 * time.Minute
+
+// Dispatcher records a delivery for every target a notification is sent to,
+// and sends due deliveries over the channel of their target's kind
+type Dispatcher struct {
+	db       *gorm.DB
+	channels map[string]Channel
+	policy   events.RetryPolicy
+	wake     chan struct{}
+}
+
+// NewDispatcher creates a dispatcher sending over channels, keyed by kind
+func NewDispatcher(db *gorm.DB, channels map[string]Channel, policy events.RetryPolicy) *Dispatcher {
+	return &Dispatcher{
+		db:       db,
+		channels: channels,
+		policy:   policy,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Dispatch queues a delivery of a notification to each of its receiver's
+// targets of a channel. Targets of a kind without a configured adapter are
+// recorded as failed. Targets the notification was already queued for are
+// skipped, so dispatching it again sends nothing twice.
+func (d *Dispatcher) Dispatch(ctx context.Context, notification models.Notification, channel string) error {
+	var targets []models.DeliveryTarget
+	err := d.db.Where("user_id = ? AND channel = ?", notification.ReceiverID, channel).Find(&targets).Error
+	if err != nil || len(targets) == 0 {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]models.Delivery, len(targets))
+	for i, target := range targets {
+		deliveries[i] = models.Delivery{
+			NotificationID: notification.ID,
+			TargetID:       target.ID,
+			Channel:        channel,
+			Kind:           target.Kind,
+			Status:         models.DeliveryPending,
+			NextAttemptAt:  now,
+		}
+		if _, ok := d.channels[target.Kind]; !ok {
+			deliveries[i].Status = models.DeliveryFailed
+			deliveries[i].LastError = fmt.Sprintf("no %s adapter configured", target.Kind)
+		}
+	}
+	if err := d.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error; err != nil {
+		return err
+	}
+
+	// Send straight away instead of at the next poll
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start runs Process every interval, and whenever deliveries were
+// dispatched, until ctx is cancelled
+func (d *Dispatcher) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			case <-ctx.Done():
+				return
+			}
+
+			if _, err := d.Process(ctx); err != nil && ctx.Err() == nil {
+				log.Println("Failed to send deliveries:", err)
+			}
+		}
+	}()
+}
+
+// Process sends the due deliveries, in batches, and returns how many were
+// sent. Deliveries are claimed for claimLease first, so replicas never send
+// the same delivery at once.
+func (d *Dispatcher) Process(ctx context.Context) (int, error) {
+	var sent int
+	for {
+		claimed, err := d.claim(time.Now())
+		if err != nil || len(claimed) == 0 {
+			return sent, err
+		}
+
+		sent += d.send(ctx, claimed)
+		if len(claimed) < batchSize {
+			return sent, nil
+		}
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+	}
+}
+
+// claim takes a batch of due deliveries, skipping deliveries another replica
+// is claiming
+func (d *Dispatcher) claim(now time.Time) ([]models.Delivery, error) {
+	var claimed []models.Delivery
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []string{models.DeliveryPending, models.DeliveryRetrying}, now).
+			Order("next_attempt_at").
+			Limit(batchSize).
+			Find(&claimed).Error
+		if err != nil || len(claimed) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(claimed))
+		for i, delivery := range claimed {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&models.Delivery{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(claimLease)).Error
+	})
+	return claimed, err
+}
+
+// send attempts claimed deliveries concurrently and records the outcomes,
+// returning how many were sent
+func (d *Dispatcher) send(ctx context.Context, claimed []models.Delivery) int {
+	notificationIDs := make([]uint, len(claimed))
+	targetIDs := make([]uint, len(claimed))
+	for i, delivery := range claimed {
+		notificationIDs[i] = delivery.NotificationID
+		targetIDs[i] = delivery.TargetID
+	}
+
+	// OutOfBandOnly notifications are stored deleted, so load deleted ones too
+	var notifications []models.Notification
+	var targets []models.DeliveryTarget
+	if err := d.db.Unscoped().Where("id IN ?", notificationIDs).Find(&notifications).Error; err != nil {
+		log.Println("Failed to load notifications of deliveries:", err)
+		return 0
+	}
+	if err := d.db.Where("id IN ?", targetIDs).Find(&targets).Error; err != nil {
+		log.Println("Failed to load delivery targets:", err)
+		return 0
+	}
+
+	notificationsByID := make(map[uint]models.Notification, len(notifications))
+	for _, notification := range notifications {
+		if !notification.Deleted() {
+			notificationsByID[notification.ID] = notification
+		}
+	}
+	targetsByID := make(map[uint]models.DeliveryTarget, len(targets))
+	for _, target := range targets {
+		targetsByID[target.ID] = target
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sent int
+	for _, delivery := range claimed {
+		wg.Add(1)
+		go func(delivery models.Delivery) {
+			defer wg.Done()
+
+			sendErr := d.attempt(ctx, delivery, notificationsByID, targetsByID)
+			if ctx.Err() != nil {
+				// Interrupted by shutdown; sent again once the claim expires
+				return
+			}
+			if err := d.record(delivery, sendErr); err != nil {
+				log.Printf("Failed to record delivery %d: %v", delivery.ID, err)
+			}
+			if sendErr == nil {
+				mu.Lock()
+				sent++
+				mu.Unlock()
+			}
+		}(delivery)
+	}
+	wg.Wait()
+	return sent
+}
+
+// attempt sends one delivery
+func (d *Dispatcher) attempt(ctx context.Context, delivery models.Delivery, notifications map[uint]models.Notification, targets map[uint]models.DeliveryTarget) error {
+	notification, ok := notifications[delivery.NotificationID]
+	if !ok {
+		return fmt.Errorf("%w: notification was deleted", ErrPermanent)
+	}
+	target, ok := targets[delivery.TargetID]
+	if !ok {
+		return fmt.Errorf("%w: target was removed", ErrPermanent)
+	}
+	channel, ok := d.channels[target.Kind]
+	if !ok {
+		return fmt.Errorf("%w: no %s adapter configured", ErrPermanent, target.Kind)
+	}
+
+	return channel.Send(ctx, Message{DeliveryID: delivery.ID, Notification: notification, Target: target})
+}
+
+// record saves the outcome of an attempt. Failed attempts are retried with
+// backoff until the retry policy is out of attempts, except permanent
+// failures. Targets that no longer exist are removed.
+func (d *Dispatcher) record(delivery models.Delivery, err error) error {
+	attempts := delivery.Attempts + 1
+	updates := map[string]interface{}{"attempts": attempts}
+
+	switch {
+	case err == nil:
+		now := time.Now()
+		updates["status"] = models.DeliverySent
+		updates["sent_at"] = &now
+		updates["last_error"] = ""
+	case errors.Is(err, ErrPermanent) || attempts >= d.policy.MaxAttempts:
+		log.Printf("Delivery %d over %s failed after %d attempt(s): %v", delivery.ID, delivery.Kind, attempts, err)
+		updates["status"] = models.DeliveryFailed
+		updates["last_error"] = err.Error()
+	default:
+		updates["status"] = models.DeliveryRetrying
+		updates["last_error"] = err.Error()
+		updates["next_attempt_at"] = time.Now().Add(d.policy.Backoff(attempts))
+	}
+
+	if errors.Is(err, ErrTargetGone) {
+		if err := d.db.Delete(&models.DeliveryTarget{}, delivery.TargetID).Error; err != nil {
+			log.Println("Failed to remove gone delivery target:", err)
+		}
+	}
+
+	return d.db.Model(&models.Delivery{}).Where("id = ?", delivery.ID).Updates(updates).Error
+}
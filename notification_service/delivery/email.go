@@ -0,0 +1,106 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// EmailChannel sends notifications as plain text email through an SMTP
+// server, upgrading to TLS when the server offers STARTTLS
+type EmailChannel struct {
+	// Addr is the host:port of the SMTP server
+	Addr     string
+	From     string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+func (e *EmailChannel) Kind() string {
+	return KindEmail
+}
+
+func (e *EmailChannel) Send(ctx context.Context, message Message) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP address: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: e.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", e.Addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(e.Timeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return smtpError(err)
+		}
+	}
+
+	if err := client.Mail(e.From); err != nil {
+		return smtpError(err)
+	}
+	if err := client.Rcpt(message.Target.Address); err != nil {
+		return smtpError(err)
+	}
+
+	body, err := client.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := body.Write(e.compose(message)); err != nil {
+		return err
+	}
+	if err := body.Close(); err != nil {
+		return smtpError(err)
+	}
+	return client.Quit()
+}
+
+// compose writes the email of a message
+func (e *EmailChannel) compose(message Message) []byte {
+	var email bytes.Buffer
+	fmt.Fprintf(&email, "From: %s\r\n", e.From)
+	fmt.Fprintf(&email, "To: %s\r\n", message.Target.Address)
+	fmt.Fprintf(&email, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Title()))
+	fmt.Fprintf(&email, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&email, "X-Notification-ID: %d\r\n", message.Notification.ID)
+	email.WriteString("MIME-Version: 1.0\r\n")
+	email.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	email.WriteString("\r\n")
+	email.WriteString(message.Notification.Message)
+	email.WriteString("\r\n")
+	return email.Bytes()
+}
+
+// Helper function to mark SMTP replies with a 5xx code, which reject the
+// message for good, as permanent
+func smtpError(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return fmt.Errorf("%w: %v", ErrPermanent, err)
+	}
+	return err
+}
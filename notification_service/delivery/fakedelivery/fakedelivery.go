@@ -0,0 +1,373 @@
+// Package fakedelivery holds local stand-ins for the services the delivery
+// adapters send to: a web push service, FCM, APNs, a webhook receiver and an
+// SMTP server. Everything they receive is logged and kept in order, so the
+// adapters can be exercised without a network connection, by hand through
+// cmd/fakedelivery or in tests.
+//
+// Appending ?status=500 to a webhook or subscription URL makes it fail with
+// that status, and the device token "unregistered" is rejected as gone.
+package fakedelivery
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Received is a message one of the fakes received
+type Received struct {
+	Kind    string            `json:"kind"`
+	Time    time.Time         `json:"time"`
+	Target  string            `json:"target"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+}
+
+// inbox holds everything received, in order
+type inbox struct {
+	mu       sync.Mutex
+	messages []Received
+}
+
+func (i *inbox) add(message Received) {
+	message.Time = time.Now()
+	log.Printf("Received %s for %s: %s", message.Kind, message.Target, message.Body)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.messages = append(i.messages, message)
+}
+
+// subscription is a web push subscription created by the fake push service
+type subscription struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+// Server holds the fakes and what they received
+type Server struct {
+	inbox         inbox
+	mu            sync.Mutex
+	subscriptions map[string]subscription
+}
+
+// NewServer creates the fakes with nothing received yet
+func NewServer() *Server {
+	return &Server{subscriptions: make(map[string]subscription)}
+}
+
+// Handler serves the web push, FCM, APNs and webhook endpoints, and lists
+// everything received at GET /received
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /webpush/subscriptions", s.createSubscription)
+	mux.HandleFunc("POST /webpush/{id}", s.receiveWebPush)
+	mux.HandleFunc("POST /fcm/v1/projects/{project}/messages:send", s.receiveFCM)
+	mux.HandleFunc("POST /apns/3/device/{token}", s.receiveAPNs)
+	mux.HandleFunc("POST /webhook", s.receiveWebhook)
+	mux.HandleFunc("GET /received", s.listReceived)
+	mux.HandleFunc("DELETE /received", s.clearReceived)
+	return mux
+}
+
+// Received returns everything received so far, in order
+func (s *Server) Received() []Received {
+	s.inbox.mu.Lock()
+	defer s.inbox.mu.Unlock()
+	return append([]Received{}, s.inbox.messages...)
+}
+
+// Helper function to answer with the status requested by ?status=, and
+// report whether one was requested
+func requestedFailure(w http.ResponseWriter, r *http.Request) bool {
+	status, err := strconv.Atoi(r.URL.Query().Get("status"))
+	if err != nil || status < 300 {
+		return false
+	}
+	http.Error(w, "failing as requested", status)
+	return true
+}
+
+// Helper function to answer with JSON
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func (s *Server) listReceived(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Received())
+}
+
+func (s *Server) clearReceived(w http.ResponseWriter, r *http.Request) {
+	s.inbox.mu.Lock()
+	defer s.inbox.mu.Unlock()
+	s.inbox.messages = nil
+}
+
+// createSubscription creates a web push subscription as a browser would,
+// keeping its private key so messages sent to it can be decrypted
+func (s *Server) createSubscription(w http.ResponseWriter, r *http.Request) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	endpointID := fmt.Sprintf("%x", id)
+	s.mu.Lock()
+	s.subscriptions[endpointID] = subscription{key: key, auth: auth}
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"endpoint": "http://" + r.Host + "/webpush/" + endpointID,
+		"keys": map[string]string{
+			"p256dh": base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			"auth":   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	})
+}
+
+func (s *Server) receiveWebPush(w http.ResponseWriter, r *http.Request) {
+	if requestedFailure(w, r) {
+		return
+	}
+
+	s.mu.Lock()
+	sub, ok := s.subscriptions[r.PathValue("id")]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown subscription", http.StatusGone)
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "vapid t=") {
+		http.Error(w, "missing VAPID authorization", http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	plaintext, err := decryptWebPush(sub, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.inbox.add(Received{
+		Kind:    "webpush",
+		Target:  r.PathValue("id"),
+		Headers: map[string]string{"TTL": r.Header.Get("TTL"), "Urgency": r.Header.Get("Urgency")},
+		Body:    string(plaintext),
+	})
+	w.WriteHeader(http.StatusCreated)
+}
+
+// decryptWebPush decrypts an aes128gcm encoded web push message (RFC 8291)
+func decryptWebPush(sub subscription, body []byte) ([]byte, error) {
+	if len(body) < 21 {
+		return nil, errors.New("message too short")
+	}
+	salt := body[:16]
+	keyLength := int(body[20])
+	if len(body) < 21+keyLength {
+		return nil, errors.New("message too short")
+	}
+	senderKey := body[21 : 21+keyLength]
+	ciphertext := body[21+keyLength:]
+
+	senderPublic, err := ecdh.P256().NewPublicKey(senderKey)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := sub.key.ECDH(senderPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	info := append([]byte("WebPush: info\x00"), sub.key.PublicKey().Bytes()...)
+	info = append(info, senderKey...)
+	ikm := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, secret, sub.auth, info), ikm)
+
+	cek := make([]byte, 16)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	nonce := make([]byte, 12)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip the padding after the last record's delimiter
+	end := bytes.LastIndexByte(plaintext, 2)
+	if end < 0 {
+		return nil, errors.New("missing padding delimiter")
+	}
+	return plaintext[:end], nil
+}
+
+func (s *Server) receiveFCM(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Message struct {
+			Token string `json:"token"`
+		} `json:"message"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil || request.Message.Token == "" {
+		http.Error(w, `{"error": {"status": "INVALID_ARGUMENT"}}`, http.StatusBadRequest)
+		return
+	}
+	if request.Message.Token == "unregistered" {
+		http.Error(w, `{"error": {"status": "NOT_FOUND", "details": [{"errorCode": "UNREGISTERED"}]}}`, http.StatusNotFound)
+		return
+	}
+
+	s.inbox.add(Received{
+		Kind:    "fcm",
+		Target:  request.Message.Token,
+		Headers: map[string]string{"Authorization": r.Header.Get("Authorization")},
+		Body:    string(body),
+	})
+	writeJSON(w, map[string]string{"name": "projects/" + r.PathValue("project") + "/messages/" + strconv.FormatInt(time.Now().UnixNano(), 10)})
+}
+
+func (s *Server) receiveAPNs(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "bearer ") || r.Header.Get("apns-topic") == "" {
+		http.Error(w, `{"reason": "MissingProviderToken"}`, http.StatusForbidden)
+		return
+	}
+	if token == "unregistered" {
+		http.Error(w, `{"reason": "Unregistered"}`, http.StatusGone)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	s.inbox.add(Received{
+		Kind:    "apns",
+		Target:  token,
+		Headers: map[string]string{"apns-topic": r.Header.Get("apns-topic"), "apns-push-type": r.Header.Get("apns-push-type")},
+		Body:    string(body),
+	})
+	w.Header().Set("apns-id", strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+func (s *Server) receiveWebhook(w http.ResponseWriter, r *http.Request) {
+	if requestedFailure(w, r) {
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	s.inbox.add(Received{
+		Kind:   "webhook",
+		Target: r.URL.String(),
+		Headers: map[string]string{
+			"X-Webhook-ID":        r.Header.Get("X-Webhook-ID"),
+			"X-Webhook-Timestamp": r.Header.Get("X-Webhook-Timestamp"),
+			"X-Webhook-Signature": r.Header.Get("X-Webhook-Signature"),
+		},
+		Body: string(body),
+	})
+}
+
+// ServeSMTP accepts mail on listener without authentication or TLS, until
+// the listener is closed
+func (s *Server) ServeSMTP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleSMTP(conn)
+	}
+}
+
+// handleSMTP speaks just enough SMTP to receive messages
+func (s *Server) handleSMTP(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 fakedelivery ESMTP")
+	var from string
+	var to []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 fakedelivery")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			from = strings.Trim(strings.TrimSpace(line)[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			to = append(to, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" || line == ".\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			s.inbox.add(Received{
+				Kind:    "email",
+				Target:  strings.Join(to, ", "),
+				Headers: map[string]string{"From": from},
+				Body:    data.String(),
+			})
+			from, to = "", nil
+			reply("250 OK")
+		case command == "RSET":
+			from, to = "", nil
+			reply("250 OK")
+		case command == "NOOP":
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// FCMChannel sends notifications to Android and iOS devices through the
+// Firebase Cloud Messaging HTTP v1 API, or any server speaking it. The
+// target address is the device's registration token.
+type FCMChannel struct {
+	// URL is the messages:send endpoint of the Firebase project
+	URL string
+	// AccessToken is an OAuth 2.0 token of a service account of the project
+	AccessToken string
+	Client      *http.Client
+}
+
+// fcmRequest is the body of an FCM HTTP v1 send request
+type fcmRequest struct {
+	Message struct {
+		Token        string `json:"token"`
+		Notification struct {
+			Title string `json:"title"`
+			Body  string `json:"body"`
+		} `json:"notification"`
+		Data map[string]string `json:"data"`
+	} `json:"message"`
+}
+
+func (f *FCMChannel) Kind() string {
+	return KindFCM
+}
+
+func (f *FCMChannel) Send(ctx context.Context, message Message) error {
+	var request fcmRequest
+	request.Message.Token = message.Target.Address
+	request.Message.Notification.Title = message.Title()
+	request.Message.Notification.Body = message.Notification.Message
+	request.Message.Data = map[string]string{
+		"notification_id": strconv.FormatUint(uint64(message.Notification.ID), 10),
+		"topic":           message.Notification.Topic,
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+f.AccessToken)
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// FCM answers 404 UNREGISTERED for tokens of uninstalled apps
+	return checkResponse(resp, http.StatusNotFound)
+}
+
+// APNs provider tokens are valid for an hour and must not be refreshed more
+// than every 20 minutes
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsChannel sends notifications to iOS devices through the Apple Push
+// Notification service HTTP/2 API, authenticating with a signing key. The
+// target address is the device token.
+type APNsChannel struct {
+	URL    string
+	KeyID  string
+	TeamID string
+	// Topic is the bundle ID of the app
+	Topic  string
+	Client *http.Client
+
+	key *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	token     string
+	tokenTime time.Time
+}
+
+// NewAPNsChannel creates an APNs channel signing its tokens with the PEM
+// encoded .p8 key. endpoint defaults to the APNs production server.
+func NewAPNsChannel(endpoint string, key []byte, keyID, teamID, topic string, client *http.Client) (*APNsChannel, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, errors.New("APNS_KEY_ID, APNS_TEAM_ID and APNS_TOPIC must be set with APNS_KEY_FILE")
+	}
+
+	signingKey, err := jwt.ParseECPrivateKeyFromPEM(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid APNs signing key: %w", err)
+	}
+
+	if endpoint == "" {
+		endpoint = "https://api.push.apple.com"
+	}
+
+	return &APNsChannel{
+		URL:    strings.TrimSuffix(endpoint, "/"),
+		KeyID:  keyID,
+		TeamID: teamID,
+		Topic:  topic,
+		Client: client,
+		key:    signingKey,
+	}, nil
+}
+
+// apnsRequest is the body of an APNs request
+type apnsRequest struct {
+	APS struct {
+		Alert struct {
+			Title string `json:"title"`
+			Body  string `json:"body"`
+		} `json:"alert"`
+		Sound string `json:"sound"`
+	} `json:"aps"`
+	NotificationID uint   `json:"notification_id"`
+	Topic          string `json:"topic"`
+}
+
+func (a *APNsChannel) Kind() string {
+	return KindAPNs
+}
+
+func (a *APNsChannel) Send(ctx context.Context, message Message) error {
+	var request apnsRequest
+	request.APS.Alert.Title = message.Title()
+	request.APS.Alert.Body = message.Notification.Message
+	request.APS.Sound = "default"
+	request.NotificationID = message.Notification.ID
+	request.Topic = message.Notification.Topic
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	token, err := a.providerToken()
+	if err != nil {
+		return err
+	}
+
+	endpoint := a.URL + "/3/device/" + url.PathEscape(message.Target.Address)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+token)
+	req.Header.Set("apns-topic", a.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// APNs answers 410 for tokens no longer valid for the topic
+	return checkResponse(resp, http.StatusGone)
+}
+
+// providerToken returns the signed JWT authenticating requests, creating a
+// new one once the current one is about to expire
+func (a *APNsChannel) providerToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && time.Since(a.tokenTime) < apnsTokenLifetime {
+		return a.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = a.KeyID
+
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", err
+	}
+	a.token, a.tokenTime = signed, now
+	return signed, nil
+}
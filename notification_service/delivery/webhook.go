@@ -0,0 +1,68 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"notification_system/notification_service/models"
+)
+
+// WebhookChannel posts notifications as JSON to URLs registered by users.
+// Every request is signed with the target's secret, so receivers can check
+// that it came from this service.
+type WebhookChannel struct {
+	Client *http.Client
+}
+
+// webhookPayload is the body of webhook requests
+type webhookPayload struct {
+	DeliveryID   uint                `json:"delivery_id"`
+	Notification models.Notification `json:"notification"`
+}
+
+func (w *WebhookChannel) Kind() string {
+	return KindWebhook
+}
+
+func (w *WebhookChannel) Send(ctx context.Context, message Message) error {
+	body, err := json.Marshal(webhookPayload{DeliveryID: message.DeliveryID, Notification: message.Notification})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, message.Target.Address, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", strconv.FormatUint(uint64(message.DeliveryID), 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+SignWebhook(message.Target.Secret, timestamp, body))
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// 410 Gone asks for the webhook to be removed
+	return checkResponse(resp, http.StatusGone)
+}
+
+// SignWebhook returns the hex encoded HMAC-SHA256 of timestamp, a dot and
+// body under secret, sent in the X-Webhook-Signature header
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
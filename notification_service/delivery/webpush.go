@@ -0,0 +1,74 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+// Seconds a push service keeps a web push message for an offline browser
+const webPushTTL = 24 * 60 * 60
+
+// WebPushChannel sends encrypted web push messages to browser push
+// subscriptions, identifying itself with VAPID keys. The target address is
+// the subscription endpoint and its secret the subscription keys as JSON.
+type WebPushChannel struct {
+	PublicKey  string
+	PrivateKey string
+	// Subject is a mailto: or https: contact for the push service
+	Subject string
+	Client  *http.Client
+}
+
+// pushPayload is the JSON a service worker receives from web and mobile pushes
+type pushPayload struct {
+	Title          string `json:"title"`
+	Body           string `json:"body"`
+	NotificationID uint   `json:"notification_id"`
+	Topic          string `json:"topic"`
+}
+
+// Helper function to build the payload of a push message
+func newPushPayload(message Message) pushPayload {
+	return pushPayload{
+		Title:          message.Title(),
+		Body:           message.Notification.Message,
+		NotificationID: message.Notification.ID,
+		Topic:          message.Notification.Topic,
+	}
+}
+
+func (w *WebPushChannel) Kind() string {
+	return KindWebPush
+}
+
+func (w *WebPushChannel) Send(ctx context.Context, message Message) error {
+	var keys webpush.Keys
+	if err := json.Unmarshal([]byte(message.Target.Secret), &keys); err != nil {
+		return fmt.Errorf("%w: invalid subscription keys: %v", ErrPermanent, err)
+	}
+
+	payload, err := json.Marshal(newPushPayload(message))
+	if err != nil {
+		return err
+	}
+
+	subscription := &webpush.Subscription{Endpoint: message.Target.Address, Keys: keys}
+	resp, err := webpush.SendNotificationWithContext(ctx, payload, subscription, &webpush.Options{
+		HTTPClient:      w.Client,
+		Subscriber:      w.Subject,
+		VAPIDPublicKey:  w.PublicKey,
+		VAPIDPrivateKey: w.PrivateKey,
+		TTL:             webPushTTL,
+		Urgency:         webpush.UrgencyNormal,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Push services answer 404 or 410 for expired subscriptions
+	return checkResponse(resp, http.StatusNotFound, http.StatusGone)
+}
@@ -1,57 +1,129 @@
 package events
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"notification_system/notification_service/config"
-	"notification_system/notification_service/models"
-
-	"github.com/IBM/sarama"
+	"notification_system/notification_service/push"
+	"notification_system/notification_service/schedule"
+	"notification_system/notification_service/unread"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
+	"time"
 )
 
 // NotificationEvent matches the structure used in Kafka messages
-type NotificationEvent struct {
-	SenderID   int    `json:"sender_id"`
-	ReceiverID int    `json:"receiver_id"`
-	Message    string `json:"message"`
-	Topic      string `json:"topic"`
-	Status     string `json:"status"`
+type NotificationEvent = sharedevents.NotificationEvent
+
+// StartConsumer saves the messages subscriber delivers from topic as
+// notifications until ctx is cancelled. Messages that fail are retried
+// according to policy and then sent to DeadLetters. A message is only
+// acknowledged once it was stored or dead-lettered.
+func StartConsumer(ctx context.Context, subscriber broker.Subscriber, topic string, policy RetryPolicy) {
+	handler := &notificationHandler{policy: policy}
+	if err := subscriber.Subscribe(ctx, topic, handler.process); err != nil {
+		log.Println("Consumer stopped:", err)
+	}
+}
+
+// notificationHandler stores the messages delivered to this replica
+type notificationHandler struct {
+	policy RetryPolicy
+}
+
+// process stores a message, retrying transient failures with backoff and
+// dead-lettering it once it is unprocessable or out of attempts. It returns
+// an error if ctx ended before the message was dealt with.
+func (h *notificationHandler) process(ctx context.Context, msg *broker.Message) error {
+	for attempt := 1; ; attempt++ {
+		err := handleMessage(ctx, msg)
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, ErrUnprocessable) && attempt < h.policy.MaxAttempts {
+			log.Printf("Failed to store notification (attempt %d/%d), retrying: %v", attempt, h.policy.MaxAttempts, err)
+			if !sleep(ctx, h.policy.Backoff(attempt)) {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		return h.deadLetter(ctx, msg, err, attempt)
+	}
 }
 
-// StartKafkaConsumer listens for Kafka messages and saves them as notifications
-func StartKafkaConsumer(brokers []string, topic string) {
-	kafkaConfig := sarama.NewConfig()
-	kafkaConfig.Consumer.Return.Errors = true
+// deadLetter publishes a failed message to the dead-letter topic, retrying
+// until it succeeds so the message is never dropped
+func (h *notificationHandler) deadLetter(ctx context.Context, msg *broker.Message, cause error, attempts int) error {
+	log.Printf("Dead-lettering message %s/%d/%d after %d attempt(s): %v", msg.Topic, msg.Partition, msg.Offset, attempts, cause)
 
-	consumer, err := sarama.NewConsumer(brokers, kafkaConfig)
-	if err != nil {
-		log.Fatal("Failed to start consumer:", err)
+	for retry := 1; ; retry++ {
+		err := DeadLetters.Publish(ctx, msg, cause, attempts)
+		if err == nil {
+			return nil
+		}
+
+		log.Println("Failed to publish dead letter, retrying:", err)
+		if !sleep(ctx, h.policy.Backoff(retry)) {
+			return ctx.Err()
+		}
 	}
-	defer consumer.Close()
+}
 
-	partitionConsumer, err := consumer.ConsumePartition(topic, 0, sarama.OffsetNewest)
-	if err != nil {
-		log.Fatal("Failed to consume partition:", err)
+// sleep waits for d and reports whether ctx is still active afterwards
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
 	}
-	defer partitionConsumer.Close()
+}
 
-	for msg := range partitionConsumer.Messages() {
-		var event NotificationEvent
-		if err := json.Unmarshal(msg.Value, &event); err != nil {
-			log.Println("Failed to parse Kafka message:", err)
-			continue
+// handleMessage saves a message as a notification and reports its delivery.
+// Errors wrapping ErrUnprocessable mean the message can never be stored.
+func handleMessage(ctx context.Context, msg *broker.Message) error {
+	// Save the notification in the database, as the receiver's preferences allow
+	ingested, err := IngestMessage(config.DB, msg)
+	if err != nil || ingested.Skipped || ingested.Purged {
+		return err
+	}
+	notification := ingested.Notification
+
+	// Push new notifications to the receiver's connected clients
+	if ingested.Stored && !notification.OutOfBandOnly {
+		if err := push.Notify(ctx, notification); err != nil {
+			log.Println("Failed to push notification:", err)
+		}
+		if notification.Status == "unread" {
+			if err := unread.Adjust(ctx, uint(notification.ReceiverID), 1); err != nil {
+				log.Println("Failed to update unread count:", err)
+			}
 		}
+	}
 
-		// Save the notification in the database
-		notification := models.Notification{
-			SenderID:   event.SenderID,
-			ReceiverID: event.ReceiverID,
-			Message:    event.Message,
-			Topic:      event.Topic,
-			Status:     event.Status,
+	// Out-of-band channels wait for the end of the receiver's quiet hours.
+	// They are scheduled on every delivery of the message, and scheduling
+	// them again does nothing, so a failure is retried with the message and
+	// a crash before they were scheduled is made up by the redelivery.
+	if !notification.Deleted() {
+		if err := schedule.Deliver(ctx, config.DB, notification, ingested.Channels, ingested.Event.Priority); err != nil {
+			return fmt.Errorf("failed to deliver notification %d: %w", notification.ID, err)
 		}
+	}
+
+	if notification.OutOfBandOnly {
+		return nil
+	}
 
-		config.DB.Create(&notification)
-		log.Println("Stored notification:", notification)
+	// Delivery receipts are best effort: the notification is stored, so a
+	// broker outage must not retry or dead-letter the message. Redelivered
+	// messages report it again, under the same receipt event ID.
+	if err := PublishReceipt(ctx, sharedevents.NotificationDelivered, notification, ingested.Envelope.CorrelationID); err != nil {
+		log.Println("Failed to publish delivery receipt:", err)
 	}
+	return nil
 }
@@ -0,0 +1,219 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/preferences"
+	"notification_system/notification_service/schedule"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
+
+	"gorm.io/gorm"
+)
+
+// failingPublisher rejects every message, like a broker that is down
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, broker.Message) error {
+	return errors.New("broker unavailable")
+}
+
+func (failingPublisher) Close() error {
+	return nil
+}
+
+// consumed returns the messages published to topic
+func consumed(t *testing.T, memory *broker.Memory, topic string) []*broker.Message {
+	t.Helper()
+
+	var messages []*broker.Message
+	err := memory.Scan(context.Background(), topic, func(msg *broker.Message) bool {
+		messages = append(messages, msg)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestReceiptFailureDoesNotFailIngestion(t *testing.T) {
+	db := setupDB(t)
+	memory := broker.NewMemory()
+	config.KafkaProducer = failingPublisher{}
+	config.NotificationTopic = "notification_events"
+	config.EventFormat = sharedevents.FormatEnvelope
+	defer func() { config.KafkaProducer = nil }()
+
+	publishEvent(t, memory, "friendship_events", 1, 2)
+	msg := consumed(t, memory, "friendship_events")[0]
+
+	if err := handleMessage(context.Background(), msg); err != nil {
+		t.Fatalf("handleMessage = %v, want the receipt failure to be logged only", err)
+	}
+	var count int64
+	db.Model(&models.Notification{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d notifications stored, want 1", count)
+	}
+}
+
+// recordingDispatcher records the out-of-band deliveries it is asked for,
+// failing the first failures of them
+type recordingDispatcher struct {
+	dispatched []string
+	failures   int
+}
+
+func (d *recordingDispatcher) Dispatch(ctx context.Context, notification models.Notification, channel string) error {
+	if d.failures > 0 {
+		d.failures--
+		return errors.New("delivery queue unavailable")
+	}
+	d.dispatched = append(d.dispatched, channel)
+	return nil
+}
+
+// useDispatcher makes schedule.Deliver dispatch to a recordingDispatcher
+func useDispatcher(t *testing.T) *recordingDispatcher {
+	t.Helper()
+
+	dispatcher := &recordingDispatcher{}
+	previous := schedule.Deliveries
+	schedule.Deliveries = dispatcher
+	t.Cleanup(func() { schedule.Deliveries = previous })
+	return dispatcher
+}
+
+// setPreference sets whether a user receives friend requests over a channel
+func setPreference(t *testing.T, db *gorm.DB, userID uint, channel string, enabled bool) {
+	t.Helper()
+
+	preference := models.NotificationPreference{UserID: userID, Topic: "friend_request", Channel: channel, Enabled: enabled}
+	if err := db.Create(&preference).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInAppDisabledStillDeliversOutOfBand(t *testing.T) {
+	db := setupDB(t)
+	dispatcher := useDispatcher(t)
+	memory := broker.NewMemory()
+	setPreference(t, db, 2, preferences.ChannelInApp, false)
+	setPreference(t, db, 2, preferences.ChannelEmail, true)
+
+	publishEvent(t, memory, "friendship_events", 1, 2)
+	if err := handleMessage(context.Background(), consumed(t, memory, "friendship_events")[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	var visible int64
+	db.Model(&models.Notification{}).Count(&visible)
+	if visible != 0 {
+		t.Errorf("%d notifications in the inbox, want none with in_app disabled", visible)
+	}
+	var stored models.Notification
+	if err := db.Unscoped().First(&stored).Error; err != nil || !stored.OutOfBandOnly || stored.Deleted() {
+		t.Errorf("stored %+v (%v), want an out-of-band only notification", stored, err)
+	}
+
+	slices.Sort(dispatcher.dispatched)
+	if want := []string{preferences.ChannelEmail, preferences.ChannelPush}; !slices.Equal(dispatcher.dispatched, want) {
+		t.Errorf("dispatched over %v, want %v", dispatcher.dispatched, want)
+	}
+}
+
+func TestReplayAppliesPreferences(t *testing.T) {
+	db := setupDB(t)
+	dispatcher := useDispatcher(t)
+	memory := broker.NewMemory()
+	// User 2 turned every channel of friend requests off, user 3 only in_app
+	setPreference(t, db, 2, preferences.ChannelInApp, false)
+	setPreference(t, db, 2, preferences.ChannelPush, false)
+	setPreference(t, db, 3, preferences.ChannelInApp, false)
+
+	publishEvent(t, memory, "friendship_events", 1, 2)
+	publishEvent(t, memory, "friendship_events", 1, 3)
+	messages := consumed(t, memory, "friendship_events")
+
+	ingested, err := IngestMessage(db, messages[0])
+	if err != nil || !ingested.Skipped || ingested.Stored {
+		t.Errorf("ingested %+v, %v, want the disabled notification skipped", ingested, err)
+	}
+	ingested, err = IngestMessage(db, messages[1])
+	if err != nil || !ingested.Stored || !ingested.Notification.OutOfBandOnly {
+		t.Errorf("ingested %+v, %v, want an out-of-band only notification", ingested, err)
+	}
+
+	var visible int64
+	db.Model(&models.Notification{}).Count(&visible)
+	if visible != 0 {
+		t.Errorf("%d notifications in the inbox, want none", visible)
+	}
+	if len(dispatcher.dispatched) != 0 {
+		t.Errorf("replay dispatched over %v, want no out-of-band deliveries", dispatcher.dispatched)
+	}
+}
+
+func TestFailedDeliveryIsRetriedWithTheMessage(t *testing.T) {
+	db := setupDB(t)
+	dispatcher := useDispatcher(t)
+	dispatcher.failures = 1
+	memory := broker.NewMemory()
+
+	publishEvent(t, memory, "friendship_events", 1, 2)
+	msg := consumed(t, memory, "friendship_events")[0]
+
+	if err := handleMessage(context.Background(), msg); err == nil {
+		t.Fatal("handleMessage succeeded although the push delivery failed")
+	}
+	// The retry finds the notification stored, and delivers it anyway
+	if err := handleMessage(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	db.Model(&models.Notification{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d notifications stored, want 1", count)
+	}
+	if want := []string{preferences.ChannelPush}; !slices.Equal(dispatcher.dispatched, want) {
+		t.Errorf("dispatched over %v, want %v", dispatcher.dispatched, want)
+	}
+}
+
+func TestRedeliveryDefersOnce(t *testing.T) {
+	db := setupDB(t)
+	dispatcher := useDispatcher(t)
+	memory := broker.NewMemory()
+
+	// Quiet from an hour ago until an hour from now
+	now := time.Now().UTC()
+	quietHours := models.QuietHours{UserID: 2, Start: now.Add(-time.Hour).Format("15:04"), End: now.Add(time.Hour).Format("15:04"), Timezone: "UTC"}
+	if err := db.Create(&quietHours).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	publishEvent(t, memory, "friendship_events", 1, 2)
+	msg := consumed(t, memory, "friendship_events")[0]
+	for i := 0; i < 2; i++ {
+		if err := handleMessage(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deferred int64
+	db.Model(&models.DeferredDelivery{}).Count(&deferred)
+	if deferred != 1 {
+		t.Errorf("%d deferred deliveries, want 1", deferred)
+	}
+	if len(dispatcher.dispatched) != 0 {
+		t.Errorf("dispatched over %v during quiet hours", dispatcher.dispatched)
+	}
+}
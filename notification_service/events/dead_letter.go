@@ -0,0 +1,279 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"notification_system/notification_service/models"
+	"notification_system/shared/broker"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DeadLetters is the dead-letter queue set up by InitDeadLetterQueue
+var DeadLetters *DeadLetterQueue
+
+// ErrDeadLetterNotFound is returned when no dead letter exists at a position
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is the message published to the dead-letter topic for an event
+// that could not be processed
+type DeadLetter struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Key       []byte            `json:"key,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Payload   []byte            `json:"payload"`
+	Error     string            `json:"error"`
+	Attempts  int               `json:"attempts"`
+	FailedAt  time.Time         `json:"failed_at"`
+}
+
+// DeadLetterRecord is a dead letter together with its position in the dead-letter topic
+type DeadLetterRecord struct {
+	DeadLetter
+	DLQPartition int32 `json:"dlq_partition"`
+	DLQOffset    int64 `json:"dlq_offset"`
+	// RedrivenAt is when the dead letter was last re-driven, if ever
+	RedrivenAt *time.Time `json:"redriven_at,omitempty"`
+}
+
+// OriginHeader carries the event ID a legacy message without one was first
+// consumed under. Re-driving gives the message a new position, so without it
+// the ID derived from the position would change and the event be stored twice.
+const OriginHeader = "x-origin-event-id"
+
+// Dead letters whose re-drives are looked up in one query while listing
+const redriveLookupBatch = 100
+
+// DeadLetterQueue publishes, lists and re-drives dead letters. Re-drives are
+// recorded in db, so bulk re-drives skip dead letters re-driven before.
+type DeadLetterQueue struct {
+	db        *gorm.DB
+	topic     string
+	publisher broker.Publisher
+	scanner   broker.Scanner
+}
+
+// InitDeadLetterQueue sets up DeadLetters for the given dead-letter topic
+func InitDeadLetterQueue(db *gorm.DB, publisher broker.Publisher, scanner broker.Scanner, topic string) {
+	DeadLetters = NewDeadLetterQueue(db, publisher, scanner, topic)
+}
+
+// NewDeadLetterQueue creates a dead-letter queue for the given topic
+func NewDeadLetterQueue(db *gorm.DB, publisher broker.Publisher, scanner broker.Scanner, topic string) *DeadLetterQueue {
+	return &DeadLetterQueue{db: db, topic: topic, publisher: publisher, scanner: scanner}
+}
+
+// Publish dead-letters a message that failed with cause after the given number of attempts
+func (q *DeadLetterQueue) Publish(ctx context.Context, msg *broker.Message, cause error, attempts int) error {
+	deadLetter := DeadLetter{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Payload:   msg.Value,
+		Error:     cause.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now().UTC(),
+	}
+
+	if len(msg.Headers) > 0 {
+		deadLetter.Headers = make(map[string]string, len(msg.Headers))
+		for _, header := range msg.Headers {
+			deadLetter.Headers[string(header.Key)] = string(header.Value)
+		}
+	}
+
+	value, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	return q.publisher.Publish(ctx, broker.Message{
+		Topic: q.topic,
+		Key:   msg.Key,
+		Value: value,
+	})
+}
+
+// List returns up to limit dead letters of partition, or of every partition
+// when it is negative, starting at offset, oldest first within each partition.
+// Reading the topic stops once limit dead letters were found.
+func (q *DeadLetterQueue) List(ctx context.Context, partition int32, offset int64, limit int) ([]DeadLetterRecord, error) {
+	return q.list(ctx, partition, offset, limit, true)
+}
+
+// Pending returns up to limit dead letters that were never re-driven
+func (q *DeadLetterQueue) Pending(ctx context.Context, limit int) ([]DeadLetterRecord, error) {
+	return q.list(ctx, -1, 0, limit, false)
+}
+
+func (q *DeadLetterQueue) list(ctx context.Context, partition int32, offset int64, limit int, redriven bool) ([]DeadLetterRecord, error) {
+	batchSize := redriveLookupBatch
+	if limit > 0 && limit < batchSize {
+		batchSize = limit
+	}
+
+	records := []DeadLetterRecord{}
+	var batch []DeadLetterRecord
+	// flush lists the records of the batch once their re-drives are known,
+	// and reports whether more are needed
+	flush := func() (bool, error) {
+		if err := q.loadRedrives(batch); err != nil {
+			return false, err
+		}
+		for _, record := range batch {
+			if redriven || record.RedrivenAt == nil {
+				records = append(records, record)
+				if len(records) == limit {
+					return false, nil
+				}
+			}
+		}
+		batch = batch[:0]
+		return true, nil
+	}
+
+	more := true
+	var lookupErr error
+	err := q.scan(ctx, partition, offset, func(record DeadLetterRecord) bool {
+		batch = append(batch, record)
+		if len(batch) == batchSize {
+			more, lookupErr = flush()
+		}
+		return more
+	})
+	if err == nil && more && len(batch) > 0 {
+		_, err = flush()
+	}
+	if err == nil {
+		err = lookupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Get returns the dead letter at a position in the dead-letter topic,
+// reading that message alone
+func (q *DeadLetterQueue) Get(ctx context.Context, partition int32, offset int64) (DeadLetterRecord, error) {
+	if partition < 0 {
+		return DeadLetterRecord{}, ErrDeadLetterNotFound
+	}
+
+	var found []DeadLetterRecord
+	err := q.scan(ctx, partition, offset, func(record DeadLetterRecord) bool {
+		// A message that expired leaves the next one first
+		if record.DLQOffset == offset {
+			found = append(found, record)
+		}
+		return false
+	})
+	if err != nil {
+		return DeadLetterRecord{}, err
+	}
+	if len(found) == 0 {
+		return DeadLetterRecord{}, ErrDeadLetterNotFound
+	}
+	if err := q.loadRedrives(found); err != nil {
+		return DeadLetterRecord{}, err
+	}
+	return found[0], nil
+}
+
+// Redrive publishes the original message of a dead letter back to its
+// original topic, and records the re-drive in the database.
+// Ingestion is idempotent, so re-driving an event twice stores it once.
+func (q *DeadLetterQueue) Redrive(ctx context.Context, record DeadLetterRecord) error {
+	msg := broker.Message{
+		Topic: record.Topic,
+		Key:   record.Key,
+		Value: record.Payload,
+	}
+	for key, value := range record.Headers {
+		msg.Headers = append(msg.Headers, broker.Header{Key: []byte(key), Value: []byte(value)})
+	}
+	if _, ok := record.Headers[OriginHeader]; !ok {
+		origin := fmt.Sprintf("kafka:%s:%d:%d", record.Topic, record.Partition, record.Offset)
+		msg.Headers = append(msg.Headers, broker.Header{Key: []byte(OriginHeader), Value: []byte(origin)})
+	}
+
+	if err := q.publisher.Publish(ctx, msg); err != nil {
+		return err
+	}
+
+	redrive := models.DeadLetterRedrive{
+		Topic:        q.topic,
+		DLQPartition: record.DLQPartition,
+		DLQOffset:    record.DLQOffset,
+		RedrivenAt:   time.Now().UTC(),
+	}
+	return q.db.Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"redriven_at"})}).Create(&redrive).Error
+}
+
+// Close shuts down the dead-letter publisher and scanner
+func (q *DeadLetterQueue) Close() error {
+	if err := q.scanner.Close(); err != nil {
+		return err
+	}
+	return q.publisher.Close()
+}
+
+// scan reads the dead letters of partition, or of every partition when it
+// is negative, from offset on until visit returns false
+func (q *DeadLetterQueue) scan(ctx context.Context, partition int32, offset int64, visit func(DeadLetterRecord) bool) error {
+	var decodeErr error
+	read := func(msg *broker.Message) bool {
+		record := DeadLetterRecord{DLQPartition: msg.Partition, DLQOffset: msg.Offset}
+		if err := json.Unmarshal(msg.Value, &record.DeadLetter); err != nil {
+			decodeErr = fmt.Errorf("invalid dead letter at %d/%d: %w", msg.Partition, msg.Offset, err)
+			return false
+		}
+		return visit(record)
+	}
+
+	var err error
+	if partition < 0 {
+		err = q.scanner.ScanFrom(ctx, q.topic, broker.Position{Offset: offset}, read)
+	} else {
+		err = q.scanner.ScanPartition(ctx, q.topic, partition, offset, read)
+	}
+	if err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+// loadRedrives sets when each of records was last re-driven
+func (q *DeadLetterQueue) loadRedrives(records []DeadLetterRecord) error {
+	offsets := make(map[int32][]int64)
+	for _, record := range records {
+		offsets[record.DLQPartition] = append(offsets[record.DLQPartition], record.DLQOffset)
+	}
+
+	redriven := make(map[[2]int64]time.Time)
+	for partition, partitionOffsets := range offsets {
+		var redrives []models.DeadLetterRedrive
+		err := q.db.Where("topic = ? AND dlq_partition = ? AND dlq_offset IN ?", q.topic, partition, partitionOffsets).Find(&redrives).Error
+		if err != nil {
+			return err
+		}
+		for _, redrive := range redrives {
+			redriven[[2]int64{int64(redrive.DLQPartition), redrive.DLQOffset}] = redrive.RedrivenAt
+		}
+	}
+
+	for i, record := range records {
+		if redrivenAt, ok := redriven[[2]int64{int64(record.DLQPartition), record.DLQOffset}]; ok {
+			records[i].RedrivenAt = &redrivenAt
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"notification_system/shared/broker"
+)
+
+func TestRedrivenLegacyMessageKeepsItsEventID(t *testing.T) {
+	db := setupDB(t)
+	memory := broker.NewMemory()
+	queue := NewDeadLetterQueue(db, memory, memory, "friendship_events.dlq")
+	ctx := context.Background()
+
+	// A legacy message, preceded by another one so its offset is not 0
+	publishEvent(t, memory, "friendship_events", 1, 2)
+	legacy := `{"sender_id": 5, "receiver_id": 2, "message": "You have received a new friend request", "topic": "friend_request", "status": "unread"}`
+	memory.Publish(ctx, broker.Message{Topic: "friendship_events", Value: []byte(legacy)})
+
+	var original broker.Message
+	memory.Scan(ctx, "friendship_events", func(msg *broker.Message) bool {
+		original = *msg
+		return true
+	})
+
+	// The message was stored before it failed, say on publishing a receipt
+	if _, err := IngestMessage(db, &original); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Publish(ctx, &original, errors.New("receipt topic unavailable"), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := queue.Pending(ctx, 0)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("pending dead letters = %v, %v, want 1", records, err)
+	}
+
+	// Re-drive it twice, as a bulk re-drive and a manual one would
+	for i := 0; i < 2; i++ {
+		if err := queue.Redrive(ctx, records[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var redriven []broker.Message
+	memory.ScanFrom(ctx, "friendship_events", broker.Position{Offset: original.Offset + 1}, func(msg *broker.Message) bool {
+		redriven = append(redriven, *msg)
+		return true
+	})
+	if len(redriven) != 2 {
+		t.Fatalf("%d messages re-driven, want 2", len(redriven))
+	}
+	for _, msg := range redriven {
+		ingested, err := IngestMessage(db, &msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ingested.Stored {
+			t.Errorf("re-driven message at offset %d was stored again", msg.Offset)
+		}
+	}
+
+	// Bulk re-drives skip dead letters that were re-driven before
+	pending, err := queue.Pending(ctx, 0)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("pending dead letters after re-drive = %v, %v, want none", pending, err)
+	}
+	all, err := queue.List(ctx, -1, 0, 0)
+	if err != nil || len(all) != 1 || all[0].RedrivenAt == nil {
+		t.Errorf("listed dead letters = %+v, %v, want the dead letter with its re-drive time", all, err)
+	}
+}
+
+// countingScanner counts the messages a scan reads
+type countingScanner struct {
+	*broker.Memory
+	read int
+}
+
+func (s *countingScanner) count(visit func(*broker.Message) bool) func(*broker.Message) bool {
+	return func(msg *broker.Message) bool {
+		s.read++
+		return visit(msg)
+	}
+}
+
+func (s *countingScanner) ScanFrom(ctx context.Context, topic string, from broker.Position, visit func(*broker.Message) bool) error {
+	return s.Memory.ScanFrom(ctx, topic, from, s.count(visit))
+}
+
+func (s *countingScanner) ScanPartition(ctx context.Context, topic string, partition int32, offset int64, visit func(*broker.Message) bool) error {
+	return s.Memory.ScanPartition(ctx, topic, partition, offset, s.count(visit))
+}
+
+func TestDeadLettersAreReadByPosition(t *testing.T) {
+	db := setupDB(t)
+	memory := broker.NewMemory()
+	scanner := &countingScanner{Memory: memory}
+	queue := NewDeadLetterQueue(db, memory, scanner, "friendship_events.dlq")
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		publishEvent(t, memory, "friendship_events", 1, 2)
+	}
+	for _, msg := range consumed(t, memory, "friendship_events") {
+		if err := queue.Publish(ctx, msg, errors.New("database unavailable"), 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record, err := queue.Get(ctx, 0, 7)
+	if err != nil || record.DLQOffset != 7 || record.Offset != 7 || scanner.read != 1 {
+		t.Errorf("Get = %+v, %v after reading %d messages, want the dead letter at 7 alone", record, err, scanner.read)
+	}
+	for _, position := range [][2]int64{{0, 10}, {1, 0}, {-1, 0}} {
+		if _, err := queue.Get(ctx, int32(position[0]), position[1]); !errors.Is(err, ErrDeadLetterNotFound) {
+			t.Errorf("Get(%d, %d) = %v, want ErrDeadLetterNotFound", position[0], position[1], err)
+		}
+	}
+
+	scanner.read = 0
+	records, err := queue.List(ctx, 0, 4, 3)
+	if err != nil || len(records) != 3 || records[0].DLQOffset != 4 || records[2].DLQOffset != 6 || scanner.read != 3 {
+		t.Errorf("List = %+v, %v after reading %d messages, want offsets 4 to 6 alone", records, err, scanner.read)
+	}
+
+	// A re-drive shows in Get and List, and takes the dead letter out of Pending
+	if err := queue.Redrive(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := queue.Get(ctx, 0, 7); err != nil || record.RedrivenAt == nil {
+		t.Errorf("Get after re-drive = %+v, %v, want its re-drive time", record, err)
+	}
+	pending, err := queue.Pending(ctx, 0)
+	if err != nil || len(pending) != 9 || slices.ContainsFunc(pending, func(record DeadLetterRecord) bool { return record.DLQOffset == 7 }) {
+		t.Errorf("Pending = %d dead letters, %v, want the 9 never re-driven", len(pending), err)
+	}
+}
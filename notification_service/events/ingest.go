@@ -0,0 +1,159 @@
+package events
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/preferences"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventID returns the ID used to deduplicate a message. Legacy messages carry
+// no ID, so one is derived from their position in the topic, which stays the
+// same when the message is delivered again. Re-driven legacy messages keep
+// the ID of their original position from OriginHeader.
+func EventID(msg *broker.Message, envelope sharedevents.Envelope) string {
+	if envelope.EventID != "" {
+		return envelope.EventID
+	}
+	for _, header := range msg.Headers {
+		if strings.EqualFold(string(header.Key), OriginHeader) && len(header.Value) > 0 {
+			return string(header.Value)
+		}
+	}
+	return fmt.Sprintf("kafka:%s:%d:%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// DecodeNotification reads the envelope carried by a message and its
+// notification event. Errors wrapping ErrUnprocessable mean the message can
+// never be stored.
+func DecodeNotification(msg *broker.Message) (sharedevents.Envelope, NotificationEvent, error) {
+	envelope, err := sharedevents.DecodeMessage(msg.Headers, msg.Value)
+	if err != nil {
+		return envelope, NotificationEvent{}, fmt.Errorf("%w: failed to parse message: %v", ErrUnprocessable, err)
+	}
+
+	event, err := envelope.NotificationEvent()
+	if err != nil {
+		return envelope, NotificationEvent{}, fmt.Errorf("%w: failed to parse %s event payload: %v", ErrUnprocessable, envelope.Type, err)
+	}
+
+	return envelope, event, nil
+}
+
+// Ingested describes what IngestMessage did with a message
+type Ingested struct {
+	Envelope     sharedevents.Envelope
+	Event        NotificationEvent
+	Notification models.Notification
+	// Channels are the channels the receiver's preferences enable
+	Channels map[string]bool
+	// Stored reports whether a new row was written
+	Stored bool
+	// Skipped reports that every channel is disabled, so nothing was stored
+	Skipped bool
+	// Purged reports that the event's notification was stored before and
+	// purged by retention since, so nothing was stored again
+	Purged bool
+}
+
+// IngestMessage saves the notification carried by a message to db, which may
+// be scoped to a table other than notifications. Purged events and the
+// receiver's preferences are read from config.DB: an event whose notification
+// was purged is not stored again, with every channel disabled nothing is
+// stored, and with in_app disabled the notification is stored OutOfBandOnly,
+// for its out-of-band deliveries alone.
+func IngestMessage(db *gorm.DB, msg *broker.Message) (Ingested, error) {
+	var ingested Ingested
+	var err error
+	ingested.Envelope, ingested.Event, err = DecodeNotification(msg)
+	if err != nil {
+		return ingested, err
+	}
+
+	eventID := EventID(msg, ingested.Envelope)
+	if ingested.Purged, err = WasPurged(eventID); err != nil {
+		return ingested, err
+	}
+	if ingested.Purged {
+		log.Println("Skipped purged event:", eventID)
+		return ingested, nil
+	}
+
+	var enabled bool
+	ingested.Channels, enabled, err = ReceiverChannels(ingested.Event)
+	if err != nil {
+		return ingested, err
+	}
+	if !enabled {
+		log.Printf("Skipped %s notification for user %d, disabled by preferences", ingested.Event.Topic, ingested.Event.ReceiverID)
+		ingested.Skipped = true
+		return ingested, nil
+	}
+
+	inApp := ingested.Channels[preferences.ChannelInApp]
+	ingested.Notification, ingested.Stored, err = StoreNotification(db, eventID, ingested.Event, inApp)
+	return ingested, err
+}
+
+// WasPurged reports whether the retention purge removed the notification of
+// an event, according to config.DB
+func WasPurged(eventID string) (bool, error) {
+	var count int64
+	err := config.DB.Model(&models.PurgedEvent{}).Where("event_id = ?", eventID).Count(&count).Error
+	return count > 0, err
+}
+
+// ReceiverChannels returns the channels the receiver of an event enabled for
+// its topic in config.DB, and whether any of them is
+func ReceiverChannels(event NotificationEvent) (map[string]bool, bool, error) {
+	channels, err := preferences.EnabledChannels(config.DB, uint(event.ReceiverID), event.Topic)
+	if err != nil {
+		return nil, false, err
+	}
+	enabled := slices.ContainsFunc(preferences.Channels, func(channel string) bool { return channels[channel] })
+	return channels, enabled, nil
+}
+
+// StoreNotification saves the notification carried by an event. An event
+// whose ID was already stored is treated as success without writing a second
+// row, and the stored notification is returned; stored reports whether a new
+// row was written. Without inApp the notification is stored OutOfBandOnly.
+func StoreNotification(db *gorm.DB, eventID string, event NotificationEvent, inApp bool) (notification models.Notification, stored bool, err error) {
+	notification = models.Notification{
+		EventID:    &eventID,
+		SenderID:   event.SenderID,
+		ReceiverID: event.ReceiverID,
+		Message:    event.Message,
+		Topic:      event.Topic,
+		Status:     event.Status,
+	}
+	if !inApp {
+		notification.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		notification.OutOfBandOnly = true
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification)
+	if result.Error != nil {
+		return notification, false, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		log.Println("Skipped duplicate event:", eventID)
+		// The stored notification may have been deleted by its receiver since
+		err = db.Unscoped().Where("event_id = ?", eventID).First(&notification).Error
+		return notification, false, err
+	}
+
+	log.Println("Stored notification:", notification)
+	return notification, true, nil
+}
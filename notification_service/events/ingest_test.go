@@ -0,0 +1,138 @@
+package events
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/retention"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupDB points config.DB at a fresh database with every table of the
+// service
+func setupDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notifications.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.Notification{}, &models.PurgedEvent{}, &models.DeadLetterRedrive{}, &models.NotificationPreference{}, &models.QuietHours{},
+		&models.DeferredDelivery{}, &models.DeliveryTarget{}, &models.Delivery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.DB = db
+	return db
+}
+
+// publishEvent publishes a friendship event from sender to receiver in the
+// envelope format
+func publishEvent(t *testing.T, memory *broker.Memory, topic string, sender, receiver int) {
+	t.Helper()
+
+	event := NotificationEvent{SenderID: sender, ReceiverID: receiver, Message: "You have received a new friend request", Topic: "friend_request", Status: "unread"}
+	envelope, err := sharedevents.NewEnvelope(event.Topic, "user_service", "", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, headers, err := sharedevents.EncodeMessage(envelope, sharedevents.FormatEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.Publish(context.Background(), broker.Message{Topic: topic, Value: value, Headers: headers}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayingBatchStoresItOnce(t *testing.T) {
+	db := setupDB(t)
+	memory := broker.NewMemory()
+
+	publishEvent(t, memory, "friendship_events", 1, 2)
+	publishEvent(t, memory, "friendship_events", 3, 2)
+	publishEvent(t, memory, "friendship_events", 2, 4)
+	// A legacy message without an event ID is deduplicated by its offset
+	legacy := `{"sender_id": 5, "receiver_id": 2, "message": "You have received a new friend request", "topic": "friend_request", "status": "unread"}`
+	memory.Publish(context.Background(), broker.Message{Topic: "friendship_events", Value: []byte(legacy)})
+
+	replay := func() (stored int) {
+		err := memory.Scan(context.Background(), "friendship_events", func(msg *broker.Message) bool {
+			ingested, err := IngestMessage(db, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ingested.Stored {
+				stored++
+			}
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+
+	if stored := replay(); stored != 4 {
+		t.Fatalf("first replay stored %d notifications, want 4", stored)
+	}
+	if stored := replay(); stored != 0 {
+		t.Fatalf("second replay stored %d notifications, want 0", stored)
+	}
+
+	var notifications []models.Notification
+	db.Order("id").Find(&notifications)
+	if len(notifications) != 4 {
+		t.Fatalf("%d notifications stored, want a single set of 4", len(notifications))
+	}
+	seen := make(map[string]bool)
+	for _, notification := range notifications {
+		if notification.EventID == nil || seen[*notification.EventID] {
+			t.Errorf("notification %d has a missing or repeated event ID", notification.ID)
+			continue
+		}
+		seen[*notification.EventID] = true
+	}
+}
+
+func TestPurgedEventIsNotStoredAgain(t *testing.T) {
+	db := setupDB(t)
+	memory := broker.NewMemory()
+
+	publishEvent(t, memory, "friendship_events", 1, 2)
+	msg := consumed(t, memory, "friendship_events")[0]
+	if ingested, err := IngestMessage(db, msg); err != nil || !ingested.Stored {
+		t.Fatalf("ingested %+v, %v, want the notification stored", ingested, err)
+	}
+
+	// The notification expires and is purged
+	policy := retention.DefaultPolicy
+	policy.Default = 24 * time.Hour
+	if purged, err := retention.Purge(context.Background(), db, policy, time.Now().Add(48*time.Hour)); err != nil || purged != 1 {
+		t.Fatalf("Purge = %d, %v, want 1 notification purged", purged, err)
+	}
+
+	// Replaying or re-driving the event leaves it purged
+	ingested, err := IngestMessage(db, msg)
+	if err != nil || !ingested.Purged || ingested.Stored {
+		t.Errorf("ingested %+v, %v, want the purged event skipped", ingested, err)
+	}
+	if err := handleMessage(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Unscoped().Model(&models.Notification{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d notifications stored after the purge, want none", count)
+	}
+}
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
+)
+
+// ProducerName identifies this service in event envelopes
+const ProducerName = "notification_service"
+
+// PublishReceipt publishes a notification_delivered or notification_read
+// event for a notification to config.NotificationTopic. The event ID is
+// derived from the notification, so publishing the same receipt again lets
+// consumers drop the duplicate. It does nothing when InitKafka was not called.
+func PublishReceipt(ctx context.Context, receiptType string, notification models.Notification, correlationID string) error {
+	if config.KafkaProducer == nil {
+		return nil
+	}
+
+	receipt := sharedevents.NotificationReceipt{
+		NotificationID: int64(notification.ID),
+		SenderID:       notification.SenderID,
+		ReceiverID:     notification.ReceiverID,
+		Topic:          notification.Topic,
+	}
+	if notification.EventID != nil {
+		receipt.EventID = *notification.EventID
+	}
+
+	envelope, err := sharedevents.NewEnvelope(receiptType, ProducerName, correlationID, receipt)
+	if err != nil {
+		return err
+	}
+	envelope.EventID = fmt.Sprintf("%s:%d", receiptType, notification.ID)
+
+	value, headers, err := sharedevents.EncodeMessage(envelope, config.EventFormat)
+	if err != nil {
+		return err
+	}
+
+	// Key by receiver so the receipts of one user stay in order
+	return config.KafkaProducer.Publish(ctx, broker.Message{
+		Topic:   config.NotificationTopic,
+		Key:     []byte(strconv.Itoa(notification.ReceiverID)),
+		Value:   value,
+		Headers: headers,
+	})
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ErrUnprocessable marks messages that can never be processed, such as
+// malformed JSON. They are dead-lettered without being retried.
+var ErrUnprocessable = errors.New("unprocessable message")
+
+// RetryPolicy controls how often a message is retried after a transient
+// failure before it is dead-lettered
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is used for settings missing from the environment
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+}
+
+// RetryPolicyFromEnv reads the retry policy from NOTIFICATION_RETRY_MAX_ATTEMPTS,
+// NOTIFICATION_RETRY_INITIAL_BACKOFF and NOTIFICATION_RETRY_MAX_BACKOFF
+func RetryPolicyFromEnv() RetryPolicy {
+	policy := DefaultRetryPolicy
+
+	if value := os.Getenv("NOTIFICATION_RETRY_MAX_ATTEMPTS"); value != "" {
+		if attempts, err := strconv.Atoi(value); err == nil && attempts > 0 {
+			policy.MaxAttempts = attempts
+		} else {
+			log.Println("Ignoring invalid NOTIFICATION_RETRY_MAX_ATTEMPTS:", value)
+		}
+	}
+
+	if value := os.Getenv("NOTIFICATION_RETRY_INITIAL_BACKOFF"); value != "" {
+		if backoff, err := time.ParseDuration(value); err == nil && backoff > 0 {
+			policy.InitialBackoff = backoff
+		} else {
+			log.Println("Ignoring invalid NOTIFICATION_RETRY_INITIAL_BACKOFF:", value)
+		}
+	}
+
+	if value := os.Getenv("NOTIFICATION_RETRY_MAX_BACKOFF"); value != "" {
+		if backoff, err := time.ParseDuration(value); err == nil && backoff > 0 {
+			policy.MaxBackoff = backoff
+		} else {
+			log.Println("Ignoring invalid NOTIFICATION_RETRY_MAX_BACKOFF:", value)
+		}
+	}
+
+	return policy
+}
+
+// Backoff returns the delay after the given failed attempt, doubling from
+// InitialBackoff up to MaxBackoff
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
@@ -1,12 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+	_ "time/tzdata" // Quiet hours timezones, for hosts without a zoneinfo database
 
 	"notification_system/notification_service/config"
+	"notification_system/notification_service/delivery"
 	"notification_system/notification_service/events"
+	"notification_system/notification_service/push"
+	"notification_system/notification_service/retention"
 	"notification_system/notification_service/routes"
+	"notification_system/notification_service/schedule"
+	"notification_system/notification_service/unread"
+	"notification_system/shared/broker"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
@@ -25,17 +37,138 @@ func main() {
 		log.Fatal("NOTIFICATION_SERVICE_PORT environment variable not set")
 	}
 
-	// Get the Kafka broker from environment variables
-	kafkaBroker := os.Getenv("KAFKA_BROKER")
-	if kafkaBroker == "" {
-		log.Fatal("KAFKA_BROKER environment variable not set")
+	// Get the message broker from environment variables
+	brokerConfig, err := broker.ConfigFromEnv("notification_service")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Count delivered and failed receipts and dead letters per topic
+	brokerConfig.Producer.Callbacks = broker.TopicCallbacks()
+
+	// Get the Kafka consumer group from environment variables, so replicas share partitions
+	consumerGroup := os.Getenv("KAFKA_CONSUMER_GROUP")
+	if consumerGroup == "" {
+		consumerGroup = "notification_service"
+	}
+
+	// Get the number of workers per claimed partition from environment variables
+	consumerWorkers := 8
+	if value := os.Getenv("NOTIFICATION_CONSUMER_WORKERS"); value != "" {
+		consumerWorkers, err = strconv.Atoi(value)
+		if err != nil || consumerWorkers < 1 {
+			log.Fatal("NOTIFICATION_CONSUMER_WORKERS must be a positive number")
+		}
+	}
+
+	// Get the interval between reconciliations of cached unread counts from environment variables
+	reconcileInterval := 10 * time.Minute
+	if value := os.Getenv("UNREAD_RECONCILE_INTERVAL"); value != "" {
+		reconcileInterval, err = time.ParseDuration(value)
+		if err != nil || reconcileInterval <= 0 {
+			log.Fatal("UNREAD_RECONCILE_INTERVAL must be a positive duration")
+		}
+	}
+
+	// Get the interval between releases of deliveries deferred by quiet hours from environment variables
+	releaseInterval := time.Minute
+	if value := os.Getenv("QUIET_HOURS_RELEASE_INTERVAL"); value != "" {
+		releaseInterval, err = time.ParseDuration(value)
+		if err != nil || releaseInterval <= 0 {
+			log.Fatal("QUIET_HOURS_RELEASE_INTERVAL must be a positive duration")
+		}
+	}
+
+	// Get the delivery adapters and their retry policy from environment variables
+	deliveryChannels, err := delivery.ChannelsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	deliveryPolicy := delivery.RetryPolicyFromEnv()
+
+	// Get the interval between checks for deliveries due for a retry from environment variables
+	deliveryInterval := 5 * time.Second
+	if value := os.Getenv("DELIVERY_POLL_INTERVAL"); value != "" {
+		deliveryInterval, err = time.ParseDuration(value)
+		if err != nil || deliveryInterval <= 0 {
+			log.Fatal("DELIVERY_POLL_INTERVAL must be a positive duration")
+		}
+	}
+
+	// Get the notification retention policy from environment variables
+	retentionPolicy, err := retention.PolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Get the dead-letter topic from environment variables
+	deadLetterTopic := os.Getenv("KAFKA_DLQ_TOPIC")
+	if deadLetterTopic == "" {
+		deadLetterTopic = "friendship_events.dlq"
 	}
 
 	// Initialize database
 	config.ConnectDatabase()
 
-	// Start Kafka consumer in a separate goroutine
-	go events.StartKafkaConsumer([]string{kafkaBroker}, "friendship_events")
+	// Initialize the dead-letter queue for events that cannot be stored
+	publisher, err := broker.NewPublisher(brokerConfig)
+	if err != nil {
+		log.Fatal("Failed to create message publisher:", err)
+	}
+
+	scanner, err := broker.NewScanner(brokerConfig)
+	if err != nil {
+		log.Fatal("Failed to create message scanner:", err)
+	}
+
+	events.InitDeadLetterQueue(config.DB, publisher, scanner, deadLetterTopic)
+	defer events.DeadLetters.Close()
+
+	// Publish delivery and read receipts with the same publisher
+	config.InitKafka(publisher)
+
+	// Join the consumer group
+	subscriber, err := broker.NewSubscriber(brokerConfig, consumerGroup, consumerWorkers)
+	if err != nil {
+		log.Fatal("Failed to create message subscriber:", err)
+	}
+
+	// Stop consuming and serving on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Expose publisher delivery metrics at /debug/vars on an internal address
+	if metricsAddr := os.Getenv("NOTIFICATION_SERVICE_METRICS_ADDR"); metricsAddr != "" {
+		broker.ServeMetrics(ctx, metricsAddr)
+	}
+
+	// Push stored notifications to connected clients, through Redis when
+	// several replicas serve them
+	config.ConnectRedis()
+	config.InitPush()
+	push.Start(ctx, config.Redis, config.PushChannel)
+
+	// Correct cached unread counts that drifted from the database
+	unread.StartReconciler(ctx, reconcileInterval)
+
+	// Purge notifications once their retention period is over
+	retention.Start(ctx, config.DB, retentionPolicy)
+
+	// Send out-of-band notifications over email, push and webhooks, retrying failures
+	dispatcher := delivery.NewDispatcher(config.DB, deliveryChannels, deliveryPolicy)
+	dispatcher.Start(ctx, deliveryInterval)
+	schedule.Deliveries = dispatcher
+
+	// Deliver out-of-band notifications deferred by quiet hours once they end
+	schedule.Start(ctx, config.DB, releaseInterval)
+
+	// Start the event consumer in a separate goroutine
+	consumerDone := make(chan struct{})
+	go func() {
+		events.StartConsumer(ctx, subscriber, "friendship_events", events.RetryPolicyFromEnv())
+		subscriber.Close()
+		close(consumerDone)
+	}()
 
 	// Initialize Fiber app
 	app := fiber.New()
@@ -43,8 +176,19 @@ func main() {
 	// Register API routes
 	routes.RegisterRoutes(app)
 
+	go func() {
+		<-ctx.Done()
+		push.Clients.Close()
+		app.Shutdown()
+	}()
+
 	log.Println("notification service running on port", port)
 
 	// Start Fiber server
-	log.Fatal(app.Listen(":" + port))
+	if err := app.Listen(":" + port); err != nil {
+		log.Fatal(err)
+	}
+
+	// Wait for the consumer to commit its offsets before exiting
+	<-consumerDone
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// DeadLetterRedrive records when a dead letter, identified by its position in
+// the dead-letter topic, was last re-driven to its original topic
+type DeadLetterRedrive struct {
+	Topic        string `gorm:"type:varchar(255);primaryKey"`
+	DLQPartition int32  `gorm:"primaryKey;autoIncrement:false"`
+	DLQOffset    int64  `gorm:"primaryKey;autoIncrement:false"`
+	RedrivenAt   time.Time
+}
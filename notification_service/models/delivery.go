@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Delivery statuses
+const (
+	DeliveryPending  = "pending"
+	DeliverySent     = "sent"
+	DeliveryFailed   = "failed"
+	DeliveryRetrying = "retrying"
+)
+
+// DeliveryTarget is an address a user receives out-of-band notifications
+// at: an email address, a web push subscription, a mobile device token or a
+// webhook URL
+type DeliveryTarget struct {
+	ID     uint `gorm:"primaryKey" json:"id"`
+	UserID uint `gorm:"index" json:"-"`
+	// Channel is the preference channel the target belongs to
+	Channel string `gorm:"type:varchar(32)" json:"channel"`
+	// Kind selects the adapter sending to the target
+	Kind    string `gorm:"type:varchar(32)" json:"kind"`
+	Address string `gorm:"type:varchar(2048)" json:"address"`
+	// Secret holds the keys of web push subscriptions and the signing
+	// secret of webhooks
+	Secret    string    `gorm:"type:text" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is one attempt to send a notification to a delivery target,
+// retried until it was sent or failed for good. A notification is delivered
+// to a target at most once.
+type Delivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	NotificationID uint       `gorm:"uniqueIndex:idx_deliveries_notification_target,priority:1" json:"notification_id"`
+	TargetID       uint       `gorm:"uniqueIndex:idx_deliveries_notification_target,priority:2;index" json:"target_id"`
+	Channel        string     `gorm:"type:varchar(32)" json:"channel"`
+	Kind           string     `gorm:"type:varchar(32)" json:"kind"`
+	Status         string     `gorm:"type:varchar(16);index:idx_deliveries_status_next_attempt,priority:1" json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"index:idx_deliveries_status_next_attempt,priority:2" json:"next_attempt_at"`
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	SentAt         *time.Time `json:"sent_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
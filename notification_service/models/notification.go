@@ -1,13 +1,53 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Notification struct {
-	ID         uint      `gorm:"primaryKey"`
-	SenderID   int       `json:"sender_id"`
-	ReceiverID int       `json:"receiver_id"`
-	Message    string    `json:"message"`
-	Topic      string    `json:"topic"`
-	Status     string    `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         uint           `gorm:"primaryKey"`
+	EventID    *string        `gorm:"type:varchar(128);uniqueIndex" json:"event_id"`
+	SenderID   int            `json:"sender_id"`
+	ReceiverID int            `gorm:"index:idx_notifications_receiver_created,priority:1" json:"receiver_id"`
+	Message    string         `json:"message"`
+	Topic      string         `json:"topic"`
+	Status     string         `json:"status"`
+	CreatedAt  time.Time      `gorm:"index:idx_notifications_receiver_created,priority:2;index" json:"created_at"`
+	ArchivedAt *time.Time     `json:"archived_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+	// OutOfBandOnly marks notifications whose receiver disabled in_app for
+	// their topic. They are stored deleted, so they never reach the inbox,
+	// and only exist for their out-of-band deliveries.
+	OutOfBandOnly bool `gorm:"not null;default:false" json:"-"`
+}
+
+// Deleted reports whether the receiver deleted the notification, after
+// which its pending out-of-band deliveries are dropped
+func (n Notification) Deleted() bool {
+	return n.DeletedAt.Valid && !n.OutOfBandOnly
+}
+
+// ExpiredNotification is a notification the retention purge moved out of
+// the notifications table
+type ExpiredNotification struct {
+	ID         uint    `gorm:"primaryKey;autoIncrement:false"`
+	EventID    *string `gorm:"type:varchar(128)"`
+	SenderID   int
+	ReceiverID int `gorm:"index"`
+	Message    string
+	Topic      string
+	Status     string
+	CreatedAt  time.Time
+	ArchivedAt *time.Time
+	DeletedAt  *time.Time
+	ExpiredAt  time.Time
+}
+
+// PurgedEvent is the ID of an event whose notification the retention purge
+// removed. It is kept so a replayed or re-driven event is not stored again.
+type PurgedEvent struct {
+	EventID  string `gorm:"type:varchar(128);primaryKey"`
+	PurgedAt time.Time
 }
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// NotificationPreference overrides whether a user receives notifications of
+// a topic over a channel. Topics and channels without a row use the defaults
+// of the preferences package.
+type NotificationPreference struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"uniqueIndex:idx_preferences_user_topic_channel,priority:1" json:"-"`
+	Topic     string    `gorm:"type:varchar(64);uniqueIndex:idx_preferences_user_topic_channel,priority:2" json:"topic"`
+	Channel   string    `gorm:"type:varchar(32);uniqueIndex:idx_preferences_user_topic_channel,priority:3" json:"channel"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// QuietHours is the daily window in which a user does not want to be
+// disturbed. Start and End are HH:MM times in Timezone; a window whose End
+// is before its Start lasts past midnight.
+type QuietHours struct {
+	UserID    uint      `gorm:"primaryKey;autoIncrement:false" json:"-"`
+	Start     string    `gorm:"type:varchar(5)" json:"start"`
+	End       string    `gorm:"type:varchar(5)" json:"end"`
+	Timezone  string    `gorm:"type:varchar(64)" json:"timezone"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DeferredDelivery is an out-of-band delivery of a notification held back
+// until its receiver's quiet hours end. A notification is deferred at most
+// once per channel.
+type DeferredDelivery struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	NotificationID uint      `gorm:"uniqueIndex:idx_deferred_deliveries_notification_channel,priority:1" json:"notification_id"`
+	ReceiverID     uint      `gorm:"index" json:"receiver_id"`
+	Channel        string    `gorm:"type:varchar(32);uniqueIndex:idx_deferred_deliveries_notification_channel,priority:2" json:"channel"`
+	ReleaseAt      time.Time `gorm:"index" json:"release_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
@@ -0,0 +1,138 @@
+// Package preferences decides over which channels a user receives the
+// notifications of each topic
+package preferences
+
+import (
+	"fmt"
+	"slices"
+
+	"notification_system/notification_service/models"
+	sharedevents "notification_system/shared/events"
+
+	"gorm.io/gorm"
+)
+
+// Channels notifications are delivered over
+const (
+	// ChannelInApp stores the notification and pushes it to connected clients
+	ChannelInApp = "in_app"
+	ChannelEmail = "email"
+	// ChannelPush sends web and mobile push notifications
+	ChannelPush    = "push"
+	ChannelWebhook = "webhook"
+)
+
+// Channels lists every channel
+var Channels = []string{ChannelInApp, ChannelEmail, ChannelPush, ChannelWebhook}
+
+// Topics lists every notification topic, one per friendship event type that
+// notifies its receiver
+var Topics = slices.DeleteFunc(slices.Clone(sharedevents.FriendshipEventTypes()), Silent)
+
+// Event types never notified over any channel: telling users they were
+// blocked would defeat the block
+var silentTopics = []string{"blocked"}
+
+// Topics worth a push notification unless the user disables them
+var pushTopics = []string{"friend_request", "friend_request_accepted"}
+
+func init() {
+	// A topic renamed in the schema would otherwise silently notify blocked
+	// users or lose its push default
+	eventTypes := sharedevents.FriendshipEventTypes()
+	for _, topic := range slices.Concat(silentTopics, pushTopics) {
+		if !slices.Contains(eventTypes, topic) {
+			panic(fmt.Sprintf("topic %q is not an event type of the %s schema", topic, sharedevents.FriendshipEventSubject))
+		}
+	}
+}
+
+// Preference is the effective setting of one topic and channel
+type Preference struct {
+	Topic   string `json:"topic"`
+	Channel string `json:"channel"`
+	Enabled bool   `json:"enabled"`
+	// Default reports whether the user kept the default setting
+	Default bool `json:"default"`
+}
+
+// Silent reports whether events of a topic never notify their receiver
+func Silent(topic string) bool {
+	return slices.Contains(silentTopics, topic)
+}
+
+// Default reports whether a channel is enabled for a topic when the user has
+// not chosen: in-app notifications are on and push notifications are on for
+// friend requests. Email and webhooks are opt-in.
+func Default(topic, channel string) bool {
+	switch channel {
+	case ChannelInApp:
+		return true
+	case ChannelPush:
+		return slices.Contains(pushTopics, topic)
+	}
+	return false
+}
+
+// ValidTopic reports whether topic is a notification topic
+func ValidTopic(topic string) bool {
+	return slices.Contains(Topics, topic)
+}
+
+// ValidChannel reports whether channel is a delivery channel
+func ValidChannel(channel string) bool {
+	return slices.Contains(Channels, channel)
+}
+
+// EnabledChannels returns the channels a user receives notifications of a
+// topic over. Every channel is disabled for silent topics.
+func EnabledChannels(db *gorm.DB, userID uint, topic string) (map[string]bool, error) {
+	if Silent(topic) {
+		return make(map[string]bool), nil
+	}
+
+	var rows []models.NotificationPreference
+	if err := db.Where("user_id = ? AND topic = ?", userID, topic).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	enabled := make(map[string]bool, len(Channels))
+	for _, channel := range Channels {
+		enabled[channel] = Default(topic, channel)
+	}
+	for _, row := range rows {
+		enabled[row.Channel] = row.Enabled
+	}
+	return enabled, nil
+}
+
+// ForUser returns the effective preferences of a user for every topic and
+// channel
+func ForUser(db *gorm.DB, userID uint) ([]Preference, error) {
+	var rows []models.NotificationPreference
+	if err := db.Where("user_id = ?", userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	chosen := make(map[[2]string]bool, len(rows))
+	for _, row := range rows {
+		chosen[[2]string{row.Topic, row.Channel}] = row.Enabled
+	}
+
+	preferences := make([]Preference, 0, len(Topics)*len(Channels))
+	for _, topic := range Topics {
+		for _, channel := range Channels {
+			enabled, ok := chosen[[2]string{topic, channel}]
+			if !ok {
+				enabled = Default(topic, channel)
+			}
+			preferences = append(preferences, Preference{
+				Topic:   topic,
+				Channel: channel,
+				Enabled: enabled,
+				Default: !ok,
+			})
+		}
+	}
+	return preferences, nil
+}
@@ -0,0 +1,48 @@
+package push
+
+import (
+	"encoding/json"
+
+	"notification_system/notification_service/models"
+)
+
+// Types of the events pushed to clients
+const (
+	// EventNotification carries a newly stored notification
+	EventNotification = "notification"
+	// EventUnreadCount carries the user's new unread count
+	EventUnreadCount = "unread_count"
+)
+
+// Event is pushed to every connection of a user
+type Event struct {
+	UserID uint   `json:"user_id"`
+	Type   string `json:"type"`
+	// ID is the notification ID of EventNotification events, which clients
+	// resume after when they reconnect; other events have none
+	ID   uint            `json:"id,omitempty"`
+	Data json.RawMessage `json:"data"`
+}
+
+// NotificationEvent returns the event pushing a stored notification
+func NotificationEvent(notification models.Notification) (Event, error) {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		UserID: uint(notification.ReceiverID),
+		Type:   EventNotification,
+		ID:     notification.ID,
+		Data:   data,
+	}, nil
+}
+
+// UnreadCountEvent returns the event pushing a user's unread count
+func UnreadCountEvent(userID uint, count int64) (Event, error) {
+	data, err := json.Marshal(map[string]int64{"unread_count": count})
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{UserID: userID, Type: EventUnreadCount, Data: data}, nil
+}
@@ -0,0 +1,90 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"notification_system/notification_service/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Without Redis, events are only pushed to clients connected to the replica
+// that published them, which is enough for a single replica
+var (
+	redisClient  *redis.Client
+	redisChannel string
+)
+
+// Start fans events out through a Redis pub/sub channel, so a client
+// receives an event whichever replica published it. Events published on the
+// channel are delivered to Clients until ctx is cancelled. With a nil client
+// every replica only delivers its own events.
+func Start(ctx context.Context, client *redis.Client, channel string) {
+	if client == nil {
+		return
+	}
+	redisClient = client
+	redisChannel = channel
+
+	pubsub := client.Subscribe(ctx, channel)
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case message, ok := <-messages:
+				if !ok {
+					return
+				}
+
+				var event Event
+				if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+					log.Println("Ignoring invalid pushed event:", err)
+					continue
+				}
+				Clients.Deliver(event)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	log.Println("Pushing notifications through Redis channel", channel)
+}
+
+// Publish pushes an event to the connected clients of its user. Pushing is
+// best effort: clients that miss a notification get it when they reconnect
+// with their last notification ID.
+func Publish(ctx context.Context, event Event) error {
+	if redisClient == nil {
+		Clients.Deliver(event)
+		return nil
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return redisClient.Publish(ctx, redisChannel, payload).Err()
+}
+
+// Notify pushes a stored notification to the connected clients of its receiver
+func Notify(ctx context.Context, notification models.Notification) error {
+	event, err := NotificationEvent(notification)
+	if err != nil {
+		return err
+	}
+	return Publish(ctx, event)
+}
+
+// NotifyUnreadCount pushes a user's new unread count to their connected clients
+func NotifyUnreadCount(ctx context.Context, userID uint, count int64) error {
+	event, err := UnreadCountEvent(userID, count)
+	if err != nil {
+		return err
+	}
+	return Publish(ctx, event)
+}
@@ -0,0 +1,148 @@
+// Package push delivers stored notifications and other events to the
+// clients their user has connected to any notification_service replica
+package push
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Errors reported by Subscription.Err once its channel is closed, and by Subscribe
+var (
+	// ErrSlowSubscriber means the subscriber did not keep up and missed
+	// events; the client should reconnect and resume from its last ID
+	ErrSlowSubscriber = errors.New("push subscriber too slow")
+	// ErrHubClosed means the replica is shutting down
+	ErrHubClosed = errors.New("push hub closed")
+	// ErrTooManyConnections means the user already holds the maximum number
+	// of subscriptions
+	ErrTooManyConnections = errors.New("too many push connections")
+)
+
+// subscriptionBuffer is the number of events a subscriber may fall
+// behind before it is dropped
+const subscriptionBuffer = 64
+
+// Subscription receives the events pushed to one connection
+type Subscription struct {
+	UserID uint
+	// C receives the user's events; it is closed when the subscription
+	// ends, after which Err reports why
+	C <-chan Event
+
+	c   chan Event
+	err error
+	// lease holds the connection's slot in Redis, when Connect took one
+	lease    string
+	leaseTTL time.Duration
+}
+
+// Err returns why the hub closed C, or nil while C is open or after Unsubscribe
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Hub holds the subscriptions of the clients connected to this replica. A
+// user may hold several subscriptions, one per connection.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[uint]map[*Subscription]struct{}
+	closed      bool
+}
+
+// Clients is the hub of this replica's push connections
+var Clients = NewHub()
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[uint]map[*Subscription]struct{})}
+}
+
+// Subscribe registers a connection of a user, unless the user already holds
+// limit subscriptions on this replica; a limit of 0 allows any number. The
+// subscription must be ended with Unsubscribe once the connection closes.
+func (h *Hub) Subscribe(userID uint, limit int) (*Subscription, error) {
+	c := make(chan Event, subscriptionBuffer)
+	subscription := &Subscription{UserID: userID, C: c, c: c}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+	if limit > 0 && len(h.subscribers[userID]) >= limit {
+		return nil, ErrTooManyConnections
+	}
+
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[userID][subscription] = struct{}{}
+	return subscription, nil
+}
+
+// Unsubscribe ends a subscription
+func (h *Hub) Unsubscribe(subscription *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.remove(subscription, nil)
+}
+
+// Connections returns the number of subscriptions a user holds
+func (h *Hub) Connections(userID uint) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers[userID])
+}
+
+// Deliver sends an event to every subscription of its user on this replica.
+// Subscriptions whose buffer is full are dropped rather than blocking
+// delivery to the others.
+func (h *Hub) Deliver(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for subscription := range h.subscribers[event.UserID] {
+		select {
+		case subscription.c <- event:
+		default:
+			h.remove(subscription, ErrSlowSubscriber)
+		}
+	}
+}
+
+// Close ends every subscription with ErrHubClosed and rejects new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subscriptions := range h.subscribers {
+		for subscription := range subscriptions {
+			h.remove(subscription, ErrHubClosed)
+		}
+	}
+}
+
+// remove closes a subscription that is still registered; h.mu must be held
+func (h *Hub) remove(subscription *Subscription, err error) {
+	subscriptions, ok := h.subscribers[subscription.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := subscriptions[subscription]; !ok {
+		return
+	}
+
+	delete(subscriptions, subscription)
+	if len(subscriptions) == 0 {
+		delete(h.subscribers, subscription.UserID)
+	}
+
+	subscription.err = err
+	close(subscription.c)
+}
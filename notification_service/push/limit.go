@@ -0,0 +1,117 @@
+package push
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Each user's connections across replicas are a Redis sorted set of lease
+// IDs scored by the time their lease expires. Connections renew their lease
+// on every heartbeat, so the slots of a replica that crashed free up once
+// their leases expire.
+const connectionsKeyPrefix = "notifications:push:connections:"
+
+// acquireScript drops the expired leases of KEYS[1], then adds lease
+// ARGV[2] expiring at ARGV[3] unless ARGV[4] leases are live. ARGV[1] is the
+// current time; all times are in milliseconds.
+var acquireScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[4]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[2])
+redis.call("PEXPIREAT", KEYS[1], ARGV[3])
+return 1
+`)
+
+func connectionsKey(userID uint) string {
+	return connectionsKeyPrefix + strconv.FormatUint(uint64(userID), 10)
+}
+
+func milliseconds(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// Connect subscribes a connection of a user to Clients, unless the user
+// already holds limit connections; a limit of 0 allows any number. With
+// Redis the limit counts the connections on every replica, and the
+// connection holds a slot for ttl at a time, renewed by KeepAlive. Without
+// Redis, or while it fails, only this replica's connections are counted.
+// The subscription must be ended with Disconnect.
+func Connect(ctx context.Context, userID uint, limit int, ttl time.Duration) (*Subscription, error) {
+	if redisClient == nil || limit == 0 {
+		return Clients.Subscribe(userID, limit)
+	}
+
+	lease := uuid.NewString()
+	now := time.Now()
+	acquired, err := acquireScript.Run(ctx, redisClient, []string{connectionsKey(userID)},
+		milliseconds(now), lease, milliseconds(now.Add(ttl)), limit).Int()
+	if err != nil {
+		log.Println("Failed to count push connections in Redis, counting this replica's:", err)
+		return Clients.Subscribe(userID, limit)
+	}
+	if acquired == 0 {
+		return nil, ErrTooManyConnections
+	}
+
+	subscription, err := Clients.Subscribe(userID, 0)
+	if err != nil {
+		redisClient.ZRem(ctx, connectionsKey(userID), lease)
+		return nil, err
+	}
+	subscription.lease = lease
+	subscription.leaseTTL = ttl
+	return subscription, nil
+}
+
+// KeepAlive renews the connection slot of a subscription created by Connect
+func KeepAlive(ctx context.Context, subscription *Subscription) {
+	if subscription.lease == "" {
+		return
+	}
+
+	// Every lease lasts ttl, so the renewed one expires last
+	key := connectionsKey(subscription.UserID)
+	expiry := time.Now().Add(subscription.leaseTTL)
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(expiry.UnixMilli()), Member: subscription.lease})
+		pipe.PExpireAt(ctx, key, expiry)
+		return nil
+	})
+	if err != nil {
+		log.Println("Failed to renew push connection lease:", err)
+	}
+}
+
+// Disconnect ends a subscription created by Connect and frees its slot
+func Disconnect(ctx context.Context, subscription *Subscription) {
+	Clients.Unsubscribe(subscription)
+	if subscription.lease == "" {
+		return
+	}
+
+	if err := redisClient.ZRem(ctx, connectionsKey(subscription.UserID), subscription.lease).Err(); err != nil {
+		log.Println("Failed to release push connection lease:", err)
+	}
+}
+
+// Connections returns the number of connections a user holds, on every
+// replica with Redis and on this one otherwise
+func Connections(ctx context.Context, userID uint) int {
+	if redisClient == nil {
+		return Clients.Connections(userID)
+	}
+
+	count, err := redisClient.ZCount(ctx, connectionsKey(userID), milliseconds(time.Now()), "+inf").Result()
+	if err != nil {
+		log.Println("Failed to count push connections in Redis, counting this replica's:", err)
+		return Clients.Connections(userID)
+	}
+	return int(count)
+}
@@ -0,0 +1,81 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// useRedis points the push package at a fresh Redis server
+func useRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	redisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		redisClient.Close()
+		redisClient = nil
+	})
+	return server
+}
+
+func TestConnectionLimitSpansReplicas(t *testing.T) {
+	useRedis(t)
+	ctx := context.Background()
+
+	// Another replica holds one of the user's two connections
+	if err := redisClient.ZAdd(ctx, connectionsKey(1), &redis.Z{Score: float64(time.Now().Add(time.Minute).UnixMilli()), Member: "other-replica"}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription, err := Connect(ctx, 1, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Connect(ctx, 1, 2, time.Minute); !errors.Is(err, ErrTooManyConnections) {
+		t.Fatalf("third connection = %v, want ErrTooManyConnections", err)
+	}
+	if count := Connections(ctx, 1); count != 2 {
+		t.Errorf("Connections = %d, want 2", count)
+	}
+
+	// Disconnecting frees the slot
+	Disconnect(ctx, subscription)
+	subscription, err = Connect(ctx, 1, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("connection after disconnect = %v, want a free slot", err)
+	}
+	Disconnect(ctx, subscription)
+}
+
+func TestExpiredLeasesFreeTheirSlot(t *testing.T) {
+	useRedis(t)
+	ctx := context.Background()
+
+	// A replica crashed without releasing its connection
+	if err := redisClient.ZAdd(ctx, connectionsKey(1), &redis.Z{Score: float64(time.Now().Add(-time.Second).UnixMilli()), Member: "crashed-replica"}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription, err := Connect(ctx, 1, 1, time.Minute)
+	if err != nil {
+		t.Fatalf("connection = %v, want the expired slot", err)
+	}
+	defer Disconnect(ctx, subscription)
+
+	// Renewing moves the lease past its first expiry
+	first, err := redisClient.ZScore(ctx, connectionsKey(1), subscription.lease).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	KeepAlive(ctx, subscription)
+	renewed, err := redisClient.ZScore(ctx, connectionsKey(1), subscription.lease).Result()
+	if err != nil || renewed <= first {
+		t.Errorf("renewed lease expires at %v (%v), want after %v", renewed, err, first)
+	}
+}
@@ -0,0 +1,123 @@
+// Package retention purges notifications once they are older than the
+// retention period of their topic, and soft-deleted notifications once they
+// were deleted long enough ago
+package retention
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Purge modes selectable with NOTIFICATION_PURGE_MODE
+const (
+	// PurgeDelete hard-deletes expired notifications
+	PurgeDelete = "delete"
+	// PurgeArchive moves expired notifications to the expired_notifications table
+	PurgeArchive = "archive"
+)
+
+// Policy controls how long notifications are kept. A retention of 0 keeps
+// notifications forever.
+type Policy struct {
+	// Default applies to topics missing from Topics
+	Default time.Duration
+	Topics  map[string]time.Duration
+	// Deleted is how long soft-deleted notifications are kept after deletion
+	Deleted   time.Duration
+	Mode      string
+	Interval  time.Duration
+	BatchSize int
+}
+
+// DefaultPolicy keeps notifications forever and soft-deleted ones for 30 days
+var DefaultPolicy = Policy{
+	Deleted:   30 * 24 * time.Hour,
+	Mode:      PurgeDelete,
+	Interval:  time.Hour,
+	BatchSize: 500,
+}
+
+// PolicyFromEnv reads the retention policy from NOTIFICATION_RETENTION,
+// NOTIFICATION_RETENTION_TOPICS, a comma separated list of topic=retention
+// pairs, NOTIFICATION_DELETED_RETENTION, NOTIFICATION_PURGE_MODE,
+// NOTIFICATION_PURGE_INTERVAL and NOTIFICATION_PURGE_BATCH_SIZE. Retentions
+// are Go durations or a number of days such as 90d.
+func PolicyFromEnv() (Policy, error) {
+	policy := DefaultPolicy
+	policy.Topics = make(map[string]time.Duration)
+
+	var err error
+	if value := os.Getenv("NOTIFICATION_RETENTION"); value != "" {
+		if policy.Default, err = ParseRetention(value); err != nil {
+			return policy, fmt.Errorf("invalid NOTIFICATION_RETENTION: %w", err)
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("NOTIFICATION_RETENTION_TOPICS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		topic, value, ok := strings.Cut(pair, "=")
+		if !ok || topic == "" {
+			return policy, fmt.Errorf("invalid NOTIFICATION_RETENTION_TOPICS entry %q, expected topic=retention", pair)
+		}
+		retention, err := ParseRetention(value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid retention of topic %s: %w", topic, err)
+		}
+		policy.Topics[strings.TrimSpace(topic)] = retention
+	}
+
+	if value := os.Getenv("NOTIFICATION_DELETED_RETENTION"); value != "" {
+		if policy.Deleted, err = ParseRetention(value); err != nil {
+			return policy, fmt.Errorf("invalid NOTIFICATION_DELETED_RETENTION: %w", err)
+		}
+	}
+
+	if value := os.Getenv("NOTIFICATION_PURGE_MODE"); value != "" {
+		if value != PurgeDelete && value != PurgeArchive {
+			return policy, fmt.Errorf("NOTIFICATION_PURGE_MODE must be %s or %s", PurgeDelete, PurgeArchive)
+		}
+		policy.Mode = value
+	}
+
+	if value := os.Getenv("NOTIFICATION_PURGE_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+			policy.Interval = interval
+		} else {
+			log.Println("Ignoring invalid NOTIFICATION_PURGE_INTERVAL:", value)
+		}
+	}
+
+	if value := os.Getenv("NOTIFICATION_PURGE_BATCH_SIZE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			policy.BatchSize = size
+		} else {
+			log.Println("Ignoring invalid NOTIFICATION_PURGE_BATCH_SIZE:", value)
+		}
+	}
+
+	return policy, nil
+}
+
+// ParseRetention parses a Go duration or a number of days such as 90d
+func ParseRetention(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention < 0 {
+		return 0, fmt.Errorf("invalid retention %q", value)
+	}
+	return retention, nil
+}
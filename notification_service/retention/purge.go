@@ -0,0 +1,193 @@
+package retention
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/unread"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Pause between batches, so other transactions get the locks a batch held
+const batchPause = 100 * time.Millisecond
+
+// condition selects expired notifications
+type condition struct {
+	query string
+	args  []interface{}
+}
+
+// conditions returns the conditions selecting the notifications expired at now
+func (p Policy) conditions(now time.Time) []condition {
+	topics := make([]string, 0, len(p.Topics))
+	for topic := range p.Topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	var conditions []condition
+	for _, topic := range topics {
+		if retention := p.Topics[topic]; retention > 0 {
+			conditions = append(conditions, condition{"topic = ? AND created_at < ?", []interface{}{topic, now.Add(-retention)}})
+		}
+	}
+
+	if p.Default > 0 {
+		if len(topics) == 0 {
+			conditions = append(conditions, condition{"created_at < ?", []interface{}{now.Add(-p.Default)}})
+		} else {
+			conditions = append(conditions, condition{"topic NOT IN ? AND created_at < ?", []interface{}{topics, now.Add(-p.Default)}})
+		}
+	}
+
+	if p.Deleted > 0 {
+		conditions = append(conditions, condition{"deleted_at < ?", []interface{}{now.Add(-p.Deleted)}})
+	}
+
+	return conditions
+}
+
+// Start runs Purge every policy.Interval until ctx is cancelled
+func Start(ctx context.Context, db *gorm.DB, policy Policy) {
+	if len(policy.conditions(time.Now())) == 0 {
+		log.Println("Notification retention is unlimited, not purging")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				purged, err := Purge(ctx, db, policy, time.Now())
+				if err != nil && ctx.Err() == nil {
+					log.Println("Failed to purge expired notifications:", err)
+				}
+				if purged > 0 {
+					log.Printf("Purged %d expired notifications", purged)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Purge removes the notifications expired at now, in batches of
+// policy.BatchSize rows so no transaction holds its locks for long. Rows
+// locked by a concurrent purge in another replica are skipped. It returns
+// the number of notifications purged.
+func Purge(ctx context.Context, db *gorm.DB, policy Policy, now time.Time) (int64, error) {
+	var total int64
+	for _, condition := range policy.conditions(now) {
+		for {
+			purged, unreadPurged, err := purgeBatch(db, policy, condition, now)
+			total += purged
+			if err != nil {
+				return total, err
+			}
+			for receiverID, count := range unreadPurged {
+				if err := unread.Adjust(ctx, receiverID, -count); err != nil {
+					log.Println("Failed to update unread count:", err)
+				}
+			}
+			if purged < int64(policy.BatchSize) {
+				break
+			}
+
+			select {
+			case <-time.After(batchPause):
+			case <-ctx.Done():
+				return total, ctx.Err()
+			}
+		}
+	}
+	return total, nil
+}
+
+// purgeBatch deletes, or moves to the expired_notifications table, one batch
+// of notifications matching condition. It also returns how many of them were
+// counted as unread, by receiver, once the batch is committed.
+func purgeBatch(db *gorm.DB, policy Policy, condition condition, now time.Time) (int64, map[uint]int64, error) {
+	var purged int64
+	unreadPurged := make(map[uint]int64)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var expired []models.Notification
+		err := tx.Unscoped().
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where(condition.query, condition.args...).
+			Limit(policy.BatchSize).
+			Find(&expired).Error
+		if err != nil || len(expired) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(expired))
+		for i, notification := range expired {
+			ids[i] = notification.ID
+			if notification.Status == "unread" && notification.ArchivedAt == nil && !notification.DeletedAt.Valid {
+				unreadPurged[uint(notification.ReceiverID)]++
+			}
+		}
+
+		if policy.Mode == PurgeArchive {
+			rows := make([]models.ExpiredNotification, len(expired))
+			for i, notification := range expired {
+				rows[i] = models.ExpiredNotification{
+					ID:         notification.ID,
+					EventID:    notification.EventID,
+					SenderID:   notification.SenderID,
+					ReceiverID: notification.ReceiverID,
+					Message:    notification.Message,
+					Topic:      notification.Topic,
+					Status:     notification.Status,
+					CreatedAt:  notification.CreatedAt,
+					ArchivedAt: notification.ArchivedAt,
+					ExpiredAt:  now,
+				}
+				if notification.DeletedAt.Valid {
+					rows[i].DeletedAt = &notification.DeletedAt.Time
+				}
+			}
+			if err := tx.Create(&rows).Error; err != nil {
+				return err
+			}
+		}
+
+		// Remember the purged events, so replaying them stores nothing
+		var purgedEvents []models.PurgedEvent
+		for _, notification := range expired {
+			if notification.EventID != nil {
+				purgedEvents = append(purgedEvents, models.PurgedEvent{EventID: *notification.EventID, PurgedAt: now})
+			}
+		}
+		if len(purgedEvents) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&purgedEvents).Error; err != nil {
+				return err
+			}
+		}
+
+		// Deliveries are only kept as long as their notification
+		if err := tx.Where("notification_id IN ?", ids).Delete(&models.Delivery{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("notification_id IN ?", ids).Delete(&models.DeferredDelivery{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Unscoped().Where("id IN ?", ids).Delete(&models.Notification{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return purged, unreadPurged, nil
+}
@@ -0,0 +1,81 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/unread"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupPurge points config.DB and config.Redis at a fresh database and Redis
+// server
+func setupPurge(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notifications.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.Notification{}, &models.ExpiredNotification{}, &models.PurgedEvent{}, &models.DeferredDelivery{}, &models.Delivery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+
+	server := miniredis.RunT(t)
+	config.Redis = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		config.Redis.Close()
+		config.Redis = nil
+	})
+	return db
+}
+
+func TestPurgeUpdatesUnreadCounts(t *testing.T) {
+	db := setupPurge(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	old, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	notifications := []models.Notification{
+		{ReceiverID: 1, Status: "unread", Topic: "friend_request", CreatedAt: old},
+		{ReceiverID: 1, Status: "unread", Topic: "friend_request", CreatedAt: old},
+		{ReceiverID: 1, Status: "read", Topic: "friend_request", CreatedAt: old},
+		{ReceiverID: 1, Status: "unread", Topic: "friend_request", CreatedAt: recent},
+		{ReceiverID: 2, Status: "unread", Topic: "friend_request", CreatedAt: old, ArchivedAt: &old},
+		{ReceiverID: 2, Status: "unread", Topic: "friend_request", CreatedAt: recent},
+	}
+	if err := db.Create(&notifications).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Cache the counts before the purge, which must adjust them
+	for userID, want := range map[uint]int64{1: 3, 2: 1} {
+		if count, err := unread.Count(ctx, userID); err != nil || count != want {
+			t.Fatalf("user %d unread count = %d, %v, want %d", userID, count, err, want)
+		}
+	}
+
+	policy := DefaultPolicy
+	policy.Default = 24 * time.Hour
+	if purged, err := Purge(ctx, db, policy, now); err != nil || purged != 4 {
+		t.Fatalf("Purge = %d, %v, want 4 notifications purged", purged, err)
+	}
+
+	for userID, want := range map[uint]int64{1: 1, 2: 1} {
+		if count, err := config.Redis.Get(ctx, fmt.Sprintf("notifications:unread:%d", userID)).Int64(); err != nil || count != want {
+			t.Errorf("user %d cached unread count = %d, %v, want %d", userID, count, err, want)
+		}
+	}
+}
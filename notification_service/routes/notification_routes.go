@@ -10,5 +10,34 @@ func RegisterRoutes(app *fiber.App) {
 	notifications := app.Group("/notifications")
 
 	notifications.Get("/", controllers.GetNotifications)
+	notifications.Get("/unread-count", controllers.GetUnreadCount)
+	notifications.Get("/ws", controllers.UpgradePush, controllers.PushNotifications)
+	notifications.Get("/stream", controllers.StreamNotifications)
+	notifications.Get("/preferences", controllers.GetPreferences)
+	notifications.Put("/preferences", controllers.UpdatePreferences)
+	notifications.Put("/preferences/:topic/:channel", controllers.SetPreference)
+	notifications.Delete("/preferences/:topic/:channel", controllers.ResetPreference)
+	notifications.Get("/quiet-hours", controllers.GetQuietHours)
+	notifications.Put("/quiet-hours", controllers.SetQuietHours)
+	notifications.Delete("/quiet-hours", controllers.DeleteQuietHours)
+	notifications.Get("/delivery-targets", controllers.GetDeliveryTargets)
+	notifications.Post("/delivery-targets", controllers.CreateDeliveryTarget)
+	notifications.Delete("/delivery-targets/:target_id", controllers.DeleteDeliveryTarget)
+	notifications.Get("/:notification_id/deliveries", controllers.GetDeliveries)
 	notifications.Put("/:notification_id/read", controllers.MarkAsRead)
+	notifications.Put("/:notification_id/archive", controllers.ArchiveNotification)
+	notifications.Put("/:notification_id/unarchive", controllers.UnarchiveNotification)
+	notifications.Delete("/:notification_id", controllers.DeleteNotification)
+	notifications.Post("/read-all", controllers.MarkAllAsRead)
+	notifications.Post("/batch/read", controllers.MarkBatchAsRead)
+	notifications.Post("/batch/unread", controllers.MarkBatchAsUnread)
+	notifications.Post("/batch/archive", controllers.ArchiveBatch)
+	notifications.Post("/batch/delete", controllers.DeleteBatch)
+
+	admin := notifications.Group("/admin", controllers.RequireAdmin)
+
+	admin.Get("/dead-letters", controllers.ListDeadLetters)
+	admin.Post("/dead-letters/redrive", controllers.RedriveDeadLetters)
+	admin.Get("/dead-letters/:partition/:offset", controllers.GetDeadLetter)
+	admin.Post("/dead-letters/:partition/:offset/redrive", controllers.RedriveDeadLetter)
 }
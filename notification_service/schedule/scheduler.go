@@ -0,0 +1,235 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/preferences"
+	sharedevents "notification_system/shared/events"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Most deferred deliveries released in one transaction
+const releaseBatchSize = 100
+
+// Delay before a release that failed to dispatch is tried again
+const retryDelay = time.Minute
+
+// A claimed delivery is not claimed again for this long, so deliveries of a
+// replica that stopped while releasing them are released by another one
+const claimLease = 5 * time.Minute
+
+// Dispatcher sends a notification over an out-of-band channel
+type Dispatcher interface {
+	Dispatch(ctx context.Context, notification models.Notification, channel string) error
+}
+
+// Deliveries sends every out-of-band delivery, straight away or once
+// released. main sets it to the dispatcher of the delivery package.
+var Deliveries Dispatcher = logDispatcher{}
+
+// logDispatcher only logs deliveries, for tools running without a dispatcher
+type logDispatcher struct{}
+
+func (logDispatcher) Dispatch(ctx context.Context, notification models.Notification, channel string) error {
+	log.Printf("No %s adapter, not delivering notification %d", channel, notification.ID)
+	return nil
+}
+
+// Deliver sends a stored notification over the out-of-band channels enabled
+// for it. While the receiver is in their quiet hours the deliveries are
+// deferred until the quiet hours end, unless priority is urgent. Delivering
+// a notification again does not repeat deliveries already deferred or
+// dispatched, so callers may retry it.
+func Deliver(ctx context.Context, db *gorm.DB, notification models.Notification, channels map[string]bool, priority string) error {
+	var outOfBand []string
+	for _, channel := range preferences.Channels {
+		if channel != preferences.ChannelInApp && channels[channel] {
+			outOfBand = append(outOfBand, channel)
+		}
+	}
+	if len(outOfBand) == 0 {
+		return nil
+	}
+
+	if priority != sharedevents.PriorityUrgent {
+		end, quiet, err := QuietUntil(db, uint(notification.ReceiverID), time.Now())
+		if err != nil {
+			return err
+		}
+		if quiet {
+			deferred := make([]models.DeferredDelivery, len(outOfBand))
+			for i, channel := range outOfBand {
+				deferred[i] = models.DeferredDelivery{
+					NotificationID: notification.ID,
+					ReceiverID:     uint(notification.ReceiverID),
+					Channel:        channel,
+					ReleaseAt:      end,
+				}
+			}
+			return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&deferred).Error
+		}
+	}
+
+	var errs []error
+	for _, channel := range outOfBand {
+		errs = append(errs, Deliveries.Dispatch(ctx, notification, channel))
+	}
+	return errors.Join(errs...)
+}
+
+// Reschedule moves a user's deferred deliveries to the end of their current
+// quiet hours after they changed them, or releases them when the user is no
+// longer in quiet hours
+func Reschedule(db *gorm.DB, userID uint, now time.Time) error {
+	end, quiet, err := QuietUntil(db, userID, now)
+	if err != nil {
+		return err
+	}
+	if !quiet {
+		end = now
+	}
+
+	return db.Model(&models.DeferredDelivery{}).
+		Where("receiver_id = ?", userID).
+		Update("release_at", end).Error
+}
+
+// Start runs Release every interval until ctx is cancelled
+func Start(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				released, err := Release(ctx, db, time.Now())
+				if err != nil && ctx.Err() == nil {
+					log.Println("Failed to release deferred deliveries:", err)
+				}
+				if released > 0 {
+					log.Printf("Released %d deferred deliveries", released)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Release dispatches the deferred deliveries due at now, in batches.
+// Deliveries are claimed for claimLease first, so replicas never release the
+// same delivery at once. Deliveries of notifications deleted meanwhile, or
+// over channels the receiver disabled meanwhile, are dropped. It returns the
+// number of deliveries dispatched.
+func Release(ctx context.Context, db *gorm.DB, now time.Time) (int, error) {
+	var total int
+	for {
+		claimed, err := claim(db, now)
+		if err != nil || len(claimed) == 0 {
+			return total, err
+		}
+
+		released, err := release(ctx, db, claimed, now)
+		total += released
+		if err != nil || len(claimed) < releaseBatchSize {
+			return total, err
+		}
+		if ctx.Err() != nil {
+			return total, ctx.Err()
+		}
+	}
+}
+
+// claim takes a batch of due deferred deliveries, skipping deliveries
+// another replica is claiming
+func claim(db *gorm.DB, now time.Time) ([]models.DeferredDelivery, error) {
+	var claimed []models.DeferredDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("release_at <= ?", now).
+			Order("release_at").
+			Limit(releaseBatchSize).
+			Find(&claimed).Error
+		if err != nil || len(claimed) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(claimed))
+		for i, delivery := range claimed {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&models.DeferredDelivery{}).
+			Where("id IN ?", ids).
+			Update("release_at", now.Add(claimLease)).Error
+	})
+	return claimed, err
+}
+
+// release dispatches claimed deliveries and removes the ones dealt with,
+// returning how many were dispatched. Failed ones are tried again after
+// retryDelay.
+func release(ctx context.Context, db *gorm.DB, claimed []models.DeferredDelivery, now time.Time) (int, error) {
+	ids := make([]uint, len(claimed))
+	for i, delivery := range claimed {
+		ids[i] = delivery.NotificationID
+	}
+
+	// OutOfBandOnly notifications are stored deleted, so load deleted ones too
+	var notifications []models.Notification
+	if err := db.Unscoped().Where("id IN ?", ids).Find(&notifications).Error; err != nil {
+		return 0, err
+	}
+	byID := make(map[uint]models.Notification, len(notifications))
+	for _, notification := range notifications {
+		if !notification.Deleted() {
+			byID[notification.ID] = notification
+		}
+	}
+
+	var released int
+	var done, failed []uint
+	for _, delivery := range claimed {
+		notification, ok := byID[delivery.NotificationID]
+		if !ok {
+			done = append(done, delivery.ID)
+			continue
+		}
+
+		channels, err := preferences.EnabledChannels(db, delivery.ReceiverID, notification.Topic)
+		if err != nil {
+			return released, err
+		}
+		if !channels[delivery.Channel] {
+			done = append(done, delivery.ID)
+			continue
+		}
+
+		if err := Deliveries.Dispatch(ctx, notification, delivery.Channel); err != nil {
+			log.Printf("Failed to deliver notification %d over %s, retrying: %v", notification.ID, delivery.Channel, err)
+			failed = append(failed, delivery.ID)
+			continue
+		}
+		done = append(done, delivery.ID)
+		released++
+	}
+
+	if len(failed) > 0 {
+		err := db.Model(&models.DeferredDelivery{}).
+			Where("id IN ?", failed).
+			Update("release_at", now.Add(retryDelay)).Error
+		if err != nil {
+			return released, err
+		}
+	}
+	if len(done) > 0 {
+		return released, db.Where("id IN ?", done).Delete(&models.DeferredDelivery{}).Error
+	}
+	return released, nil
+}
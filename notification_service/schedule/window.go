@@ -0,0 +1,92 @@
+// Package schedule defers out-of-band deliveries of notifications received
+// during their receiver's quiet hours, and releases them once the quiet hours
+// end. In-app notifications are always stored straight away.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"notification_system/notification_service/models"
+
+	"gorm.io/gorm"
+)
+
+// Window is a user's daily quiet hours in their timezone
+type Window struct {
+	// Start and End are minutes after midnight
+	Start, End int
+	Location   *time.Location
+}
+
+// ParseWindow checks the quiet hours a user set and returns their window
+func ParseWindow(quietHours models.QuietHours) (Window, error) {
+	start, err := parseClock(quietHours.Start)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid start: %w", err)
+	}
+	end, err := parseClock(quietHours.End)
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid end: %w", err)
+	}
+	if start == end {
+		return Window{}, errors.New("start and end must differ")
+	}
+
+	location, err := time.LoadLocation(quietHours.Timezone)
+	if err != nil || quietHours.Timezone == "" {
+		return Window{}, fmt.Errorf("unknown timezone %q", quietHours.Timezone)
+	}
+
+	return Window{Start: start, End: end, Location: location}, nil
+}
+
+// Helper function to parse an HH:MM time into minutes after midnight
+func parseClock(value string) (int, error) {
+	clock, err := time.Parse("15:04", value)
+	if err != nil || len(value) != len("15:04") {
+		return 0, fmt.Errorf("%q is not an HH:MM time", value)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// Until reports whether now falls within the window, and if so when the
+// window ends
+func (w Window) Until(now time.Time) (time.Time, bool) {
+	local := now.In(w.Location)
+	minute := local.Hour()*60 + local.Minute()
+	year, month, day := local.Date()
+
+	var endDay int
+	switch {
+	case w.Start < w.End && minute >= w.Start && minute < w.End:
+		endDay = day
+	case w.Start > w.End && minute >= w.Start:
+		endDay = day + 1
+	case w.Start > w.End && minute < w.End:
+		endDay = day
+	default:
+		return time.Time{}, false
+	}
+
+	return time.Date(year, month, endDay, w.End/60, w.End%60, 0, 0, w.Location), true
+}
+
+// QuietUntil reports whether a user is in their quiet hours at now, and if
+// so when they end. Users without quiet hours are never quiet.
+func QuietUntil(db *gorm.DB, userID uint, now time.Time) (time.Time, bool, error) {
+	var quietHours models.QuietHours
+	err := db.Where("user_id = ?", userID).Limit(1).Find(&quietHours).Error
+	if err != nil || quietHours.UserID == 0 {
+		return time.Time{}, false, err
+	}
+
+	window, err := ParseWindow(quietHours)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	end, quiet := window.Until(now)
+	return end, quiet, nil
+}
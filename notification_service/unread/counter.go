@@ -0,0 +1,219 @@
+// Package unread maintains the number of unread notifications of every user.
+// The notifications table is the source of truth; counts are cached in Redis,
+// adjusted as notifications are stored, read and deleted, and periodically
+// reconciled with the table to correct any drift.
+package unread
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification_system/notification_service/config"
+	"notification_system/notification_service/models"
+	"notification_system/notification_service/push"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const keyPrefix = "notifications:unread:"
+
+// Cached counts of users that stay inactive this long expire, and are
+// counted again from the database when next needed
+const keyTTL = 24 * time.Hour
+
+// adjustScript adds ARGV[1] to a cached count without creating a missing
+// one, since a count created from an adjustment alone would be wrong
+var adjustScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+local count = redis.call("INCRBY", KEYS[1], ARGV[1])
+if count < 0 then
+	redis.call("SET", KEYS[1], 0, "KEEPTTL")
+	count = 0
+end
+return count
+`)
+
+func key(userID uint) string {
+	return keyPrefix + strconv.FormatUint(uint64(userID), 10)
+}
+
+// countFromDB counts a user's unread notifications in the notifications
+// table; archived notifications are not counted
+func countFromDB(userID uint) (int64, error) {
+	var count int64
+	err := config.DB.Model(&models.Notification{}).
+		Where("receiver_id = ? AND status = ? AND archived_at IS NULL", userID, "unread").
+		Count(&count).Error
+	return count, err
+}
+
+// Count returns a user's unread count. It is read from Redis when cached
+// there, and counted in the database and cached otherwise, or when Redis
+// is unavailable.
+func Count(ctx context.Context, userID uint) (int64, error) {
+	if config.Redis == nil {
+		return countFromDB(userID)
+	}
+
+	count, err := config.Redis.Get(ctx, key(userID)).Int64()
+	if err == nil {
+		return count, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		log.Println("Failed to read unread count from Redis, counting in the database:", err)
+		return countFromDB(userID)
+	}
+
+	count, err = countFromDB(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep a count another replica cached in the meantime
+	if err := config.Redis.SetNX(ctx, key(userID), count, keyTTL).Err(); err != nil {
+		log.Println("Failed to cache unread count:", err)
+	}
+	return count, nil
+}
+
+// Adjust adds delta to a user's unread count once a change making
+// notifications unread, or no longer unread, was committed, and pushes the
+// new count to the user's connected clients
+func Adjust(ctx context.Context, userID uint, delta int64) error {
+	count, err := adjust(ctx, userID, delta)
+	if err != nil {
+		return err
+	}
+	return push.NotifyUnreadCount(ctx, userID, count)
+}
+
+func adjust(ctx context.Context, userID uint, delta int64) (int64, error) {
+	if config.Redis == nil {
+		return countFromDB(userID)
+	}
+
+	count, err := adjustScript.Run(ctx, config.Redis, []string{key(userID)}, delta).Int64()
+	if errors.Is(err, redis.Nil) {
+		// Nothing cached, so the count is loaded from the updated table
+		return Count(ctx, userID)
+	}
+	if err != nil {
+		// Reconciliation corrects the cached count once Redis is back
+		log.Println("Failed to adjust unread count in Redis, counting in the database:", err)
+		return countFromDB(userID)
+	}
+	return count, nil
+}
+
+// Refresh counts a user's unread notifications in the database after a
+// change affecting any number of them, caches the count and pushes it to the
+// user's connected clients
+func Refresh(ctx context.Context, userID uint) error {
+	count, _, err := recount(ctx, userID, true)
+	if err != nil {
+		// Drop the cached count, adjusted meanwhile or unreachable, so it
+		// is counted again when next read
+		if config.Redis != nil {
+			log.Println("Failed to refresh unread count in Redis, counting in the database:", err)
+			config.Redis.Del(ctx, key(userID))
+		}
+		if count, err = countFromDB(userID); err != nil {
+			return err
+		}
+	}
+	return push.NotifyUnreadCount(ctx, userID, count)
+}
+
+// Reconcile resets every cached count to the count in the database and
+// pushes the counts that changed. Counts adjusted while they are being
+// reconciled are left for the next run.
+func Reconcile(ctx context.Context) error {
+	if config.Redis == nil {
+		return nil
+	}
+
+	keys := config.Redis.Scan(ctx, 0, keyPrefix+"*", 100).Iterator()
+	for keys.Next(ctx) {
+		userID, err := strconv.ParseUint(strings.TrimPrefix(keys.Val(), keyPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		count, cached, err := recount(ctx, uint(userID), false)
+		if errors.Is(err, redis.Nil) || errors.Is(err, redis.TxFailedErr) {
+			// Expired, or adjusted while counting
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if cached != count {
+			log.Printf("Reconciled unread count of user %d from %d to %d", userID, cached, count)
+			if err := push.NotifyUnreadCount(ctx, uint(userID), count); err != nil {
+				log.Println("Failed to push unread count:", err)
+			}
+		}
+	}
+	return keys.Err()
+}
+
+// recount counts a user's unread notifications in the database and caches
+// the count, returning it along with the count cached before. The count is
+// only cached if no adjustment happened meanwhile; otherwise redis.TxFailedErr
+// is returned. Without create, a count that is not cached is left alone and
+// redis.Nil is returned.
+func recount(ctx context.Context, userID uint, create bool) (count int64, cached int64, err error) {
+	if config.Redis == nil {
+		count, err = countFromDB(userID)
+		return count, count, err
+	}
+
+	err = config.Redis.Watch(ctx, func(tx *redis.Tx) error {
+		cached, err = tx.Get(ctx, key(userID)).Int64()
+		if err != nil && !(create && errors.Is(err, redis.Nil)) {
+			return err
+		}
+
+		count, err = countFromDB(userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key(userID), count, keyTTL)
+			return nil
+		})
+		return err
+	}, key(userID))
+	return count, cached, err
+}
+
+// StartReconciler runs Reconcile every interval until ctx is cancelled
+func StartReconciler(ctx context.Context, interval time.Duration) {
+	if config.Redis == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := Reconcile(ctx); err != nil && ctx.Err() == nil {
+					log.Println("Failed to reconcile unread counts:", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,192 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// ErrBufferFull is returned when an asynchronous publisher could not buffer a
+// message before its buffer timeout
+var ErrBufferFull = errors.New("publisher buffer is full")
+
+// ErrPublisherClosed is returned when publishing to a closed publisher
+var ErrPublisherClosed = errors.New("publisher is closed")
+
+// AsyncKafkaPublisher publishes messages with a sarama async producer, which
+// batches and compresses them. At most BufferSize messages wait for delivery
+// at once; publishing more blocks for up to BufferTimeout and then fails with
+// ErrBufferFull, pushing back on callers instead of growing without bound.
+type AsyncKafkaPublisher struct {
+	producer  sarama.AsyncProducer
+	callbacks DeliveryCallbacks
+	timeout   time.Duration
+	buffer    chan struct{}
+
+	mu       sync.RWMutex
+	closed   bool
+	delivery sync.WaitGroup
+}
+
+// delivery is attached to every message sent through an AsyncKafkaPublisher
+type delivery struct {
+	msg  Message
+	done func(error)
+}
+
+// NewAsyncKafkaPublisher connects an asynchronous publisher to the configured brokers
+func NewAsyncKafkaPublisher(kafka KafkaConfig, options ProducerConfig) (*AsyncKafkaPublisher, error) {
+	if options.BufferSize < 1 {
+		options.BufferSize = DefaultProducerConfig.BufferSize
+	}
+
+	config, err := kafka.NewSaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Compression = options.Compression
+	config.Producer.Flush.Frequency = options.FlushFrequency
+	config.Producer.Flush.Messages = options.FlushMessages
+	config.Producer.Flush.Bytes = options.FlushBytes
+	// A single request in flight per broker keeps messages with the same key
+	// in order when sends are retried
+	config.Net.MaxOpenRequests = 1
+
+	producer, err := sarama.NewAsyncProducer(kafka.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &AsyncKafkaPublisher{
+		producer:  producer,
+		callbacks: options.Callbacks,
+		timeout:   options.BufferTimeout,
+		buffer:    make(chan struct{}, options.BufferSize),
+	}
+
+	p.delivery.Add(2)
+	go p.handleSuccesses()
+	go p.handleErrors()
+
+	return p, nil
+}
+
+func (p *AsyncKafkaPublisher) handleSuccesses() {
+	defer p.delivery.Done()
+	for producerMessage := range p.producer.Successes() {
+		p.delivered(producerMessage, nil)
+	}
+}
+
+func (p *AsyncKafkaPublisher) handleErrors() {
+	defer p.delivery.Done()
+	for producerError := range p.producer.Errors() {
+		p.delivered(producerError.Msg, producerError.Err)
+	}
+}
+
+// delivered frees the message's buffer slot and reports the outcome
+func (p *AsyncKafkaPublisher) delivered(producerMessage *sarama.ProducerMessage, err error) {
+	<-p.buffer
+	bufferedMessages.Add(-1)
+
+	pending, _ := producerMessage.Metadata.(*delivery)
+	if pending == nil {
+		return
+	}
+
+	if err != nil {
+		p.callbacks.failure(pending.msg, err)
+	} else {
+		p.callbacks.success(pending.msg)
+	}
+
+	if pending.done != nil {
+		pending.done(err)
+	}
+}
+
+// PublishAsync buffers a message for delivery and returns without waiting
+// for the broker. done, if not nil, is called with the delivery outcome.
+func (p *AsyncKafkaPublisher) PublishAsync(ctx context.Context, msg Message, done func(error)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPublisherClosed
+	}
+
+	if err := p.reserve(ctx); err != nil {
+		rejectedMessages.Add(1)
+		return err
+	}
+	bufferedMessages.Add(1)
+
+	producerMessage := toProducerMessage(msg)
+	producerMessage.Metadata = &delivery{msg: msg, done: done}
+	p.producer.Input() <- producerMessage
+	return nil
+}
+
+// reserve waits for a free buffer slot
+func (p *AsyncKafkaPublisher) reserve(ctx context.Context) error {
+	select {
+	case p.buffer <- struct{}{}:
+		return nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if p.timeout > 0 {
+		timer := time.NewTimer(p.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case p.buffer <- struct{}{}:
+		return nil
+	case <-timeout:
+		return ErrBufferFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Publish buffers a message and waits until the broker acknowledged it.
+// Concurrent calls are batched together.
+func (p *AsyncKafkaPublisher) Publish(ctx context.Context, msg Message) error {
+	result := make(chan error, 1)
+	if err := p.PublishAsync(ctx, msg, func(err error) { result <- err }); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting messages, flushes every buffered message and waits
+// for their delivery callbacks
+func (p *AsyncKafkaPublisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	p.producer.AsyncClose()
+	p.delivery.Wait()
+	return nil
+}
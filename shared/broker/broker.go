@@ -0,0 +1,231 @@
+// Package broker abstracts the message broker both services use, so they can
+// run against Kafka or, for tests and local development, entirely in memory.
+package broker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Implementations selectable with the BROKER environment variable
+const (
+	KindKafka  = "kafka"
+	KindMemory = "memory"
+)
+
+// Header is a message header
+type Header struct {
+	Key   []byte
+	Value []byte
+}
+
+// Message is a message published to or received from a broker. Partition,
+// Offset and Timestamp are set on received messages only.
+type Message struct {
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+}
+
+// Publisher publishes messages to topics
+type Publisher interface {
+	// Publish returns once the broker accepted the message
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// Handler processes a received message and returns once it was dealt with.
+// If it returns an error, typically because ctx ended, the message is not
+// acknowledged and will be delivered again when the subscription restarts.
+type Handler func(ctx context.Context, msg *Message) error
+
+// Subscriber delivers the messages of a topic to a handler
+type Subscriber interface {
+	// Subscribe blocks, delivering messages to handler until ctx is cancelled.
+	// Messages with the same key are delivered in order.
+	Subscribe(ctx context.Context, topic string, handler Handler) error
+	Close() error
+}
+
+// Scanner reads the messages currently stored in a topic without subscribing to it
+type Scanner interface {
+	// Scan visits the messages of topic from the oldest one up to the newest
+	// one present when the scan started, until visit returns false. A scan
+	// that could not read every partition up to the newest message returns
+	// an error wrapping ErrIncompleteScan.
+	Scan(ctx context.Context, topic string, visit func(*Message) bool) error
+	// ScanFrom is like Scan but starts every partition at from
+	ScanFrom(ctx context.Context, topic string, from Position, visit func(*Message) bool) error
+	// ScanPartition is like Scan but only reads partition, starting at offset
+	ScanPartition(ctx context.Context, topic string, partition int32, offset int64, visit func(*Message) bool) error
+	Close() error
+}
+
+// Position selects where a scan starts in each partition. The zero Position
+// is the oldest message.
+type Position struct {
+	// Offset is the first offset read when Time is zero; offsets older than
+	// the oldest message still stored start at the oldest message
+	Offset int64
+	// Time, when set, starts at the first message produced at or after it
+	Time time.Time
+}
+
+// Config selects and configures a broker implementation
+type Config struct {
+	Kind     string
+	Kafka    KafkaConfig
+	Producer ProducerConfig
+	// ScanIdleTimeout is how long a Kafka scan waits for the next message of
+	// a partition before reporting it incomplete, 0 for no limit
+	ScanIdleTimeout time.Duration
+}
+
+// ProducerConfig configures Kafka publishers
+type ProducerConfig struct {
+	// Async selects the batching asynchronous producer instead of the sync one
+	Async bool
+	// Compression applies to every batch sent to Kafka
+	Compression sarama.CompressionCodec
+	// FlushFrequency, FlushMessages and FlushBytes trigger sending a batch
+	// in async mode, whichever is reached first
+	FlushFrequency time.Duration
+	FlushMessages  int
+	FlushBytes     int
+	// BufferSize bounds the messages awaiting delivery in async mode
+	BufferSize int
+	// BufferTimeout is how long publishing waits for buffer space, 0 for no limit
+	BufferTimeout time.Duration
+	// Callbacks are invoked on delivery of every message; set in code only
+	Callbacks DeliveryCallbacks
+}
+
+// DefaultProducerConfig is used for settings missing from the environment
+var DefaultProducerConfig = ProducerConfig{
+	Compression:    sarama.CompressionNone,
+	FlushFrequency: 50 * time.Millisecond,
+	FlushMessages:  100,
+	BufferSize:     10000,
+	BufferTimeout:  5 * time.Second,
+}
+
+// ConfigFromEnv reads the broker configuration from BROKER, which defaults to
+// kafka, the Kafka connection settings read by KafkaConfigFromEnv, the
+// KAFKA_PRODUCER_*, KAFKA_COMPRESSION and KAFKA_FLUSH_* producer settings and
+// KAFKA_SCAN_IDLE_TIMEOUT. clientID identifies the calling service to Kafka
+// unless KAFKA_CLIENT_ID is set.
+func ConfigFromEnv(clientID string) (Config, error) {
+	config := Config{Kind: os.Getenv("BROKER"), Producer: DefaultProducerConfig, ScanIdleTimeout: DefaultScanIdleTimeout}
+	if config.Kind == "" {
+		config.Kind = KindKafka
+	}
+
+	switch config.Kind {
+	case KindKafka:
+		kafka, err := KafkaConfigFromEnv(clientID)
+		if err != nil {
+			return config, err
+		}
+		config.Kafka = kafka
+	case KindMemory:
+	default:
+		return config, fmt.Errorf("unknown broker %q", config.Kind)
+	}
+
+	switch mode := os.Getenv("KAFKA_PRODUCER_MODE"); mode {
+	case "", "sync":
+	case "async":
+		config.Producer.Async = true
+	default:
+		return config, fmt.Errorf("KAFKA_PRODUCER_MODE must be sync or async, got %q", mode)
+	}
+
+	if value := os.Getenv("KAFKA_COMPRESSION"); value != "" {
+		if err := config.Producer.Compression.UnmarshalText([]byte(value)); err != nil {
+			return config, fmt.Errorf("invalid KAFKA_COMPRESSION: %w", err)
+		}
+	}
+
+	if err := durationFromEnv("KAFKA_FLUSH_FREQUENCY", &config.Producer.FlushFrequency); err != nil {
+		return config, err
+	}
+	if err := intFromEnv("KAFKA_FLUSH_MESSAGES", &config.Producer.FlushMessages); err != nil {
+		return config, err
+	}
+	if err := intFromEnv("KAFKA_FLUSH_BYTES", &config.Producer.FlushBytes); err != nil {
+		return config, err
+	}
+	if err := intFromEnv("KAFKA_PRODUCER_BUFFER_SIZE", &config.Producer.BufferSize); err != nil {
+		return config, err
+	}
+	if err := durationFromEnv("KAFKA_PRODUCER_BUFFER_TIMEOUT", &config.Producer.BufferTimeout); err != nil {
+		return config, err
+	}
+	if config.Producer.BufferSize < 1 {
+		return config, fmt.Errorf("KAFKA_PRODUCER_BUFFER_SIZE must be positive")
+	}
+	if err := durationFromEnv("KAFKA_SCAN_IDLE_TIMEOUT", &config.ScanIdleTimeout); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func intFromEnv(name string, target *int) error {
+	if value := os.Getenv(name); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("%s must be a non-negative number, got %q", name, value)
+		}
+		*target = parsed
+	}
+	return nil
+}
+
+func durationFromEnv(name string, target *time.Duration) error {
+	if value := os.Getenv(name); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("%s must be a non-negative duration, got %q", name, value)
+		}
+		*target = parsed
+	}
+	return nil
+}
+
+// NewPublisher creates a publisher for the configured broker
+func NewPublisher(config Config) (Publisher, error) {
+	if config.Kind == KindMemory {
+		return DefaultMemory, nil
+	}
+	if config.Producer.Async {
+		return NewAsyncKafkaPublisher(config.Kafka, config.Producer)
+	}
+	return NewKafkaPublisher(config.Kafka, config.Producer)
+}
+
+// NewSubscriber creates a subscriber in a consumer group. Messages of one
+// partition are processed by up to workers goroutines.
+func NewSubscriber(config Config, groupID string, workers int) (Subscriber, error) {
+	if config.Kind == KindMemory {
+		return DefaultMemory.Subscriber(groupID), nil
+	}
+	return NewKafkaSubscriber(config.Kafka, groupID, workers)
+}
+
+// NewScanner creates a scanner for the configured broker
+func NewScanner(config Config) (Scanner, error) {
+	if config.Kind == KindMemory {
+		return DefaultMemory, nil
+	}
+	return NewKafkaScanner(config.Kafka, config.ScanIdleTimeout)
+}
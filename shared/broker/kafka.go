@@ -0,0 +1,478 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Messages buffered per worker before a partition stops reading ahead
+const laneBufferSize = 64
+
+// KafkaPublisher publishes messages with a sarama sync producer, sending each
+// message in its own request
+type KafkaPublisher struct {
+	producer  sarama.SyncProducer
+	callbacks DeliveryCallbacks
+}
+
+// NewKafkaPublisher connects a publisher to the configured brokers
+func NewKafkaPublisher(kafka KafkaConfig, options ProducerConfig) (*KafkaPublisher, error) {
+	config, err := kafka.NewSaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+	config.Producer.Compression = options.Compression
+
+	producer, err := sarama.NewSyncProducer(kafka.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaPublisher{producer: producer, callbacks: options.Callbacks}, nil
+}
+
+// Publish sends a message and waits for the broker to acknowledge it
+func (p *KafkaPublisher) Publish(ctx context.Context, msg Message) error {
+	_, _, err := p.producer.SendMessage(toProducerMessage(msg))
+	if err != nil {
+		p.callbacks.failure(msg, err)
+		return err
+	}
+
+	p.callbacks.success(msg)
+	return nil
+}
+
+// Close shuts down the producer
+func (p *KafkaPublisher) Close() error {
+	return p.producer.Close()
+}
+
+func toProducerMessage(msg Message) *sarama.ProducerMessage {
+	producerMessage := &sarama.ProducerMessage{
+		Topic: msg.Topic,
+		Value: sarama.ByteEncoder(msg.Value),
+	}
+	if len(msg.Key) > 0 {
+		producerMessage.Key = sarama.ByteEncoder(msg.Key)
+	}
+	for _, header := range msg.Headers {
+		producerMessage.Headers = append(producerMessage.Headers, sarama.RecordHeader{Key: header.Key, Value: header.Value})
+	}
+	return producerMessage
+}
+
+func fromConsumerMessage(msg *sarama.ConsumerMessage) *Message {
+	message := &Message{
+		Topic:     msg.Topic,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+	}
+	for _, header := range msg.Headers {
+		if header != nil {
+			message.Headers = append(message.Headers, Header{Key: header.Key, Value: header.Value})
+		}
+	}
+	return message
+}
+
+// KafkaSubscriber consumes topics as a member of a sarama consumer group.
+// Replicas in the same group split the partitions between them, and a group
+// without committed offsets starts from the oldest message.
+type KafkaSubscriber struct {
+	group   sarama.ConsumerGroup
+	workers int
+}
+
+// NewKafkaSubscriber joins the consumer group groupID. Messages of one
+// partition are processed by up to workers goroutines.
+func NewKafkaSubscriber(kafka KafkaConfig, groupID string, workers int) (*KafkaSubscriber, error) {
+	config, err := kafka.NewSaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Group.Rebalance.GroupStrategies = []sarama.BalanceStrategy{sarama.NewBalanceStrategySticky()}
+
+	group, err := sarama.NewConsumerGroup(kafka.Brokers, groupID, config)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for err := range group.Errors() {
+			log.Println("Kafka consumer error:", err)
+		}
+	}()
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &KafkaSubscriber{group: group, workers: workers}, nil
+}
+
+// Subscribe consumes every partition of topic claimed by this replica
+func (s *KafkaSubscriber) Subscribe(ctx context.Context, topic string, handler Handler) error {
+	groupHandler := &kafkaGroupHandler{handler: handler, workers: s.workers}
+	for {
+		// Consume returns whenever the group rebalances, so keep rejoining
+		if err := s.group.Consume(ctx, []string{topic}, groupHandler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+			log.Println("Kafka consumer group failed:", err)
+			time.Sleep(time.Second)
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// Close leaves the group, committing the offsets of acknowledged messages
+func (s *KafkaSubscriber) Close() error {
+	return s.group.Close()
+}
+
+// kafkaGroupHandler hands the messages of the partitions claimed by this replica to a Handler
+type kafkaGroupHandler struct {
+	handler Handler
+	workers int
+}
+
+func (h *kafkaGroupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	log.Println("Kafka consumer claimed partitions:", session.Claims())
+	return nil
+}
+
+func (h *kafkaGroupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim spreads the messages of a partition over the handler's
+// workers by message key, so messages with the same key are processed in
+// order while different keys are processed concurrently. A message is marked
+// as consumed only once it and every earlier message of the partition were
+// handled, so no message has its offset committed before it was dealt with.
+// When the handler fails, the claim stops and returns the error, which ends
+// the session so the partition is consumed again from the last marked message.
+func (h *kafkaGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	// Handlers run with the session's context, so messages being handled
+	// when a worker fails are finished; ctx only stops taking new ones
+	ctx, cancel := context.WithCancel(session.Context())
+	defer cancel()
+	tracker := &offsetTracker{session: session}
+
+	var failOnce sync.Once
+	var failure error
+	fail := func(msg *sarama.ConsumerMessage, err error) {
+		failOnce.Do(func() {
+			failure = fmt.Errorf("handling message at offset %d of %s/%d: %w", msg.Offset, msg.Topic, msg.Partition, err)
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	lanes := make([]chan *trackedMessage, h.workers)
+	for i := range lanes {
+		lanes[i] = make(chan *trackedMessage, laneBufferSize)
+
+		wg.Add(1)
+		go func(lane <-chan *trackedMessage) {
+			defer wg.Done()
+			for tracked := range lane {
+				if ctx.Err() != nil {
+					return
+				}
+				if err := h.handler(session.Context(), fromConsumerMessage(tracked.msg)); err != nil {
+					if session.Context().Err() == nil {
+						fail(tracked.msg, err)
+					}
+					return
+				}
+				tracker.complete(tracked)
+			}
+		}(lanes[i])
+	}
+
+	// failed waits for the workers, then returns the handler's error if one failed
+	failed := func() error {
+		for _, lane := range lanes {
+			close(lane)
+		}
+		wg.Wait()
+		return failure
+	}
+
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return failed()
+			}
+
+			tracked := tracker.add(msg)
+			select {
+			case lanes[laneFor(msg.Key, len(lanes))] <- tracked:
+			case <-ctx.Done():
+				return failed()
+			}
+
+		case <-ctx.Done():
+			return failed()
+		}
+	}
+}
+
+// trackedMessage is a message of a claimed partition that is being processed
+type trackedMessage struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+// offsetTracker marks messages of a partition that are processed out of order.
+// A message is only marked once every earlier message of the partition is
+// done, so the committed offset never skips an unprocessed message.
+type offsetTracker struct {
+	mu      sync.Mutex
+	session sarama.ConsumerGroupSession
+	pending []*trackedMessage
+}
+
+// add starts tracking a message; messages must be added in offset order
+func (t *offsetTracker) add(msg *sarama.ConsumerMessage) *trackedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracked := &trackedMessage{msg: msg}
+	t.pending = append(t.pending, tracked)
+	return tracked
+}
+
+// complete records that a message is done and marks the newest message up to
+// which every message is done
+func (t *offsetTracker) complete(tracked *trackedMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracked.done = true
+
+	var last *sarama.ConsumerMessage
+	for len(t.pending) > 0 && t.pending[0].done {
+		last = t.pending[0].msg
+		t.pending[0] = nil
+		t.pending = t.pending[1:]
+	}
+
+	if last != nil {
+		t.session.MarkMessage(last, "")
+	}
+}
+
+// laneFor picks the worker for a message key. Messages with the same key
+// always go to the same worker, which processes them in order; unkeyed
+// messages all go to the first worker so their relative order is kept too.
+func laneFor(key []byte, lanes int) int {
+	if len(key) == 0 {
+		return 0
+	}
+
+	hash := fnv.New32a()
+	hash.Write(key)
+	return int(hash.Sum32() % uint32(lanes))
+}
+
+// KafkaScanner reads topics directly, outside of any consumer group
+type KafkaScanner struct {
+	client      sarama.Client
+	idleTimeout time.Duration
+}
+
+// NewKafkaScanner connects a scanner to the configured brokers. A partition
+// scan gives up after idleTimeout without a message, 0 waiting forever.
+func NewKafkaScanner(kafka KafkaConfig, idleTimeout time.Duration) (*KafkaScanner, error) {
+	config, err := kafka.NewSaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sarama.NewClient(kafka.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaScanner{client: client, idleTimeout: idleTimeout}, nil
+}
+
+// Scan reads every partition of topic from its oldest message up to the
+// newest one present when the partition was reached
+func (s *KafkaScanner) Scan(ctx context.Context, topic string, visit func(*Message) bool) error {
+	return s.ScanFrom(ctx, topic, Position{}, visit)
+}
+
+// ScanFrom reads every partition of topic from the given position up to the
+// newest message present when the partition was reached. Partitions that go
+// quiet for the idle timeout before their newest message are left behind and
+// the scan goes on, then returns an error wrapping ErrIncompleteScan.
+func (s *KafkaScanner) ScanFrom(ctx context.Context, topic string, from Position, visit func(*Message) bool) error {
+	consumer, err := sarama.NewConsumerFromClient(s.client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	partitions, err := s.client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+
+	var incomplete []error
+	for _, partition := range partitions {
+		more, err := s.readPartition(ctx, consumer, topic, partition, from, visit)
+		if errors.Is(err, ErrIncompleteScan) {
+			incomplete = append(incomplete, fmt.Errorf("partition %d: %w", partition, err))
+			continue
+		}
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return errors.Join(incomplete...)
+}
+
+// ScanPartition reads one partition of topic from offset up to the newest
+// message present when the scan started. A partition the topic does not
+// have holds no messages.
+func (s *KafkaScanner) ScanPartition(ctx context.Context, topic string, partition int32, offset int64, visit func(*Message) bool) error {
+	partitions, err := s.client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(partitions, partition) {
+		return nil
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(s.client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	_, err = s.readPartition(ctx, consumer, topic, partition, Position{Offset: offset}, visit)
+	if errors.Is(err, ErrIncompleteScan) {
+		return fmt.Errorf("partition %d: %w", partition, err)
+	}
+	return err
+}
+
+// readPartition visits the messages of a partition from the given position
+// up to its newest one, and reports whether the scan should go on
+func (s *KafkaScanner) readPartition(ctx context.Context, consumer sarama.Consumer, topic string, partition int32, from Position, visit func(*Message) bool) (bool, error) {
+	newest, err := s.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return false, err
+	}
+	start, err := s.startOffset(topic, partition, from)
+	if err != nil {
+		return false, err
+	}
+	if start < 0 || start >= newest {
+		return true, nil
+	}
+
+	partitionConsumer, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return false, err
+	}
+	defer partitionConsumer.Close()
+
+	return scanPartition(ctx, partitionConsumer, start, newest, s.idleTimeout, visit)
+}
+
+// startOffset resolves a position to an offset of a partition, or -1 when no
+// message of the partition is at or after it
+func (s *KafkaScanner) startOffset(topic string, partition int32, from Position) (int64, error) {
+	if !from.Time.IsZero() {
+		return s.client.GetOffset(topic, partition, from.Time.UnixMilli())
+	}
+
+	oldest, err := s.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	return max(oldest, from.Offset), nil
+}
+
+// Close disconnects the scanner
+func (s *KafkaScanner) Close() error {
+	return s.client.Close()
+}
+
+// ErrIncompleteScan is returned by scans that gave up on a partition before
+// its newest message, after waiting the idle timeout for the next one.
+// Offsets without a message, such as compacted records and transaction
+// markers, are never delivered, but a slow or failing broker looks the same.
+var ErrIncompleteScan = errors.New("scan ended before the newest message")
+
+// DefaultScanIdleTimeout is used when KAFKA_SCAN_IDLE_TIMEOUT is not set
+const DefaultScanIdleTimeout = 5 * time.Second
+
+// scanPartition visits the messages of a partition from start up to newest,
+// the high water mark when the scan reached the partition. It reports
+// whether the scan should go on with the next partition. A partition quiet
+// for idleTimeout, unless it is 0, ends with an error wrapping ErrIncompleteScan.
+func scanPartition(ctx context.Context, partitionConsumer sarama.PartitionConsumer, start, newest int64, idleTimeout time.Duration, visit func(*Message) bool) (bool, error) {
+	var idle <-chan time.Time
+	var timer *time.Timer
+	if idleTimeout > 0 {
+		timer = time.NewTimer(idleTimeout)
+		defer timer.Stop()
+		idle = timer.C
+	}
+
+	next := start
+	for {
+		select {
+		case msg, ok := <-partitionConsumer.Messages():
+			if !ok {
+				return true, nil
+			}
+			if msg.Offset >= newest {
+				// Produced after the scan started
+				return true, nil
+			}
+			if !visit(fromConsumerMessage(msg)) {
+				return false, nil
+			}
+			next = msg.Offset + 1
+			if next >= newest {
+				return true, nil
+			}
+			if timer != nil {
+				timer.Reset(idleTimeout)
+			}
+
+		case <-idle:
+			return true, fmt.Errorf("%w: stopped at offset %d of %d after nothing for %s", ErrIncompleteScan, next, newest, idleTimeout)
+
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASL mechanisms selectable with KAFKA_SASL_MECHANISM
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// KafkaConfig holds the connection settings shared by every Kafka client, so
+// publishers, subscribers and scanners all connect the same way
+type KafkaConfig struct {
+	Brokers []string
+	// ClientID identifies the service to the brokers, for logs and quotas
+	ClientID string
+	// Version pins the protocol version; the zero value keeps sarama's default
+	Version sarama.KafkaVersion
+	TLS     TLSConfig
+	SASL    SASLConfig
+}
+
+// TLSConfig configures encryption of broker connections
+type TLSConfig struct {
+	Enabled bool
+	// CAFile verifies the brokers with a custom CA instead of the system roots
+	CAFile string
+	// CertFile and KeyFile authenticate this client with a certificate
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name verified in broker certificates
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// SASLConfig configures authentication with the brokers
+type SASLConfig struct {
+	// Mechanism is SASLPlain, SASLScramSHA256 or SASLScramSHA512, or empty
+	// to disable SASL
+	Mechanism string
+	Username  string
+	Password  string
+}
+
+// KafkaConfigFromEnv reads the Kafka connection settings from KAFKA_BROKERS,
+// a comma separated list that falls back to KAFKA_BROKER, KAFKA_CLIENT_ID,
+// which defaults to clientID, KAFKA_VERSION, KAFKA_TLS_* and KAFKA_SASL_*
+func KafkaConfigFromEnv(clientID string) (KafkaConfig, error) {
+	config := KafkaConfig{ClientID: clientID}
+
+	brokers := os.Getenv("KAFKA_BROKERS")
+	if brokers == "" {
+		brokers = os.Getenv("KAFKA_BROKER")
+	}
+	for _, broker := range strings.Split(brokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			config.Brokers = append(config.Brokers, broker)
+		}
+	}
+	if len(config.Brokers) == 0 {
+		return config, fmt.Errorf("KAFKA_BROKERS environment variable not set")
+	}
+
+	if value := os.Getenv("KAFKA_CLIENT_ID"); value != "" {
+		config.ClientID = value
+	}
+
+	if value := os.Getenv("KAFKA_VERSION"); value != "" {
+		version, err := sarama.ParseKafkaVersion(value)
+		if err != nil {
+			return config, fmt.Errorf("invalid KAFKA_VERSION: %w", err)
+		}
+		config.Version = version
+	}
+
+	config.TLS = TLSConfig{
+		Enabled:            os.Getenv("KAFKA_TLS_ENABLED") == "true",
+		CAFile:             os.Getenv("KAFKA_TLS_CA_FILE"),
+		CertFile:           os.Getenv("KAFKA_TLS_CERT_FILE"),
+		KeyFile:            os.Getenv("KAFKA_TLS_KEY_FILE"),
+		ServerName:         os.Getenv("KAFKA_TLS_SERVER_NAME"),
+		InsecureSkipVerify: os.Getenv("KAFKA_TLS_INSECURE_SKIP_VERIFY") == "true",
+	}
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		return config, fmt.Errorf("KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE must be set together")
+	}
+
+	config.SASL = SASLConfig{
+		Mechanism: strings.ToUpper(os.Getenv("KAFKA_SASL_MECHANISM")),
+		Username:  os.Getenv("KAFKA_SASL_USERNAME"),
+		Password:  os.Getenv("KAFKA_SASL_PASSWORD"),
+	}
+	switch config.SASL.Mechanism {
+	case "":
+	case SASLPlain, SASLScramSHA256, SASLScramSHA512:
+		if config.SASL.Username == "" {
+			return config, fmt.Errorf("KAFKA_SASL_USERNAME must be set for %s", config.SASL.Mechanism)
+		}
+	default:
+		return config, fmt.Errorf("unknown KAFKA_SASL_MECHANISM %q", config.SASL.Mechanism)
+	}
+
+	return config, nil
+}
+
+// NewSaramaConfig builds the sarama configuration every Kafka client starts
+// from; callers add their producer or consumer settings on top of it
+func (c KafkaConfig) NewSaramaConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+
+	if c.ClientID != "" {
+		config.ClientID = c.ClientID
+	}
+	if c.Version != (sarama.KafkaVersion{}) {
+		config.Version = c.Version
+	}
+
+	if c.TLS.Enabled || c.TLS.CAFile != "" || c.TLS.CertFile != "" {
+		tlsConfig, err := c.TLS.build()
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	switch c.SASL.Mechanism {
+	case "":
+	case SASLPlain:
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLScramSHA256:
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.SHA256}
+		}
+	case SASLScramSHA512:
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.SHA512}
+		}
+	default:
+		return nil, fmt.Errorf("unknown SASL mechanism %q", c.SASL.Mechanism)
+	}
+	if config.Net.SASL.Enable {
+		config.Net.SASL.User = c.SASL.Username
+		config.Net.SASL.Password = c.SASL.Password
+	}
+
+	return config, nil
+}
+
+// build loads the certificates of a TLS configuration
+func (c TLSConfig) build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Kafka CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in Kafka CA file %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Kafka client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// scramClient implements sarama.SCRAMClient with xdg-go/scram
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,168 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// fakePartitionConsumer delivers the messages it was given, then nothing
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+	messages chan *sarama.ConsumerMessage
+}
+
+func newFakePartitionConsumer(offsets ...int64) *fakePartitionConsumer {
+	consumer := &fakePartitionConsumer{messages: make(chan *sarama.ConsumerMessage, len(offsets))}
+	for _, offset := range offsets {
+		consumer.messages <- &sarama.ConsumerMessage{Topic: "topic", Offset: offset}
+	}
+	return consumer
+}
+
+func (c *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func TestScanPartitionEndsAtNewest(t *testing.T) {
+	var visited []int64
+	more, err := scanPartition(context.Background(), newFakePartitionConsumer(0, 1, 2), 0, 3, DefaultScanIdleTimeout, func(msg *Message) bool {
+		visited = append(visited, msg.Offset)
+		return true
+	})
+	if err != nil || !more || len(visited) != 3 {
+		t.Errorf("scan = %v, %v after visiting %v, want every message", more, err, visited)
+	}
+}
+
+func TestScanPartitionReportsTrailingGap(t *testing.T) {
+	// Offset 2 is a transaction marker, or a message the broker is slow to
+	// return, so the last message delivered is at 1 while the high water
+	// mark is 3
+	var visited []int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		more, err := scanPartition(context.Background(), newFakePartitionConsumer(0, 1), 0, 3, 50*time.Millisecond, func(msg *Message) bool {
+			visited = append(visited, msg.Offset)
+			return true
+		})
+		if !errors.Is(err, ErrIncompleteScan) || !more {
+			t.Errorf("scan = %v, %v, want to go on with the next partition and report this one incomplete", more, err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan did not end after the idle timeout")
+	}
+	if len(visited) != 2 {
+		t.Errorf("visited %v, want offsets 0 and 1", visited)
+	}
+}
+
+func TestScanPartitionWaitsWithoutIdleTimeout(t *testing.T) {
+	consumer := newFakePartitionConsumer(0)
+	result := make(chan error, 1)
+	go func() {
+		_, err := scanPartition(context.Background(), consumer, 0, 2, 0, func(msg *Message) bool { return true })
+		result <- err
+	}()
+
+	// The second message arrives late, and is still read
+	time.Sleep(50 * time.Millisecond)
+	consumer.messages <- &sarama.ConsumerMessage{Topic: "topic", Offset: 1}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("scan = %v, want every message read", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan did not end after the last message")
+	}
+}
+
+// fakeSession records the messages marked as consumed
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	mu     sync.Mutex
+	marked int64
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = msg.Offset
+}
+
+// fakeClaim delivers a partition's messages from offset on, then nothing
+// until closed
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(keys []string, offset int64) *fakeClaim {
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, len(keys))}
+	for ; offset < int64(len(keys)); offset++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "topic", Key: []byte(keys[offset]), Offset: offset}
+	}
+	return claim
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func TestConsumeClaimRestartsAfterHandlerFailure(t *testing.T) {
+	// The message at offset 1 fails once; enough messages of its key follow
+	// to fill its worker's buffer
+	keys := []string{"alice", "bob", "carol"}
+	for i := 0; i < 2*laneBufferSize; i++ {
+		keys = append(keys, "bob", "carol")
+	}
+	var failed atomic.Bool
+	handler := &kafkaGroupHandler{workers: 4, handler: func(ctx context.Context, msg *Message) error {
+		if msg.Offset == 1 && !failed.Swap(true) {
+			return errors.New("dead letter topic unavailable")
+		}
+		return nil
+	}}
+
+	session := &fakeSession{ctx: context.Background(), marked: -1}
+	result := make(chan error, 1)
+	go func() { result <- handler.ConsumeClaim(session, newFakeClaim(keys, 0)) }()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatal("ConsumeClaim = nil, want the handler's error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ConsumeClaim stalled after the handler failed")
+	}
+	if session.marked != 0 {
+		t.Fatalf("marked offset %d, want 0 before the failed message", session.marked)
+	}
+
+	// The next session starts after the last marked message and gets through
+	// the rest of the partition
+	claim := newFakeClaim(keys, session.marked+1)
+	close(claim.messages)
+	if err := handler.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(keys) - 1); session.marked != want {
+		t.Errorf("marked offset %d after restarting, want %d", session.marked, want)
+	}
+}
@@ -0,0 +1,153 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultMemory is the in-memory broker shared by everything in the process
+// when BROKER is memory
+var DefaultMemory = NewMemory()
+
+// Memory is an in-process broker. Each topic is a single partition log kept
+// in memory, and each consumer group remembers how far it got, so a
+// subscription that restarts resumes where the group left off. Subscribers
+// wait on a channel that is closed whenever a topic grows.
+type Memory struct {
+	mu      sync.Mutex
+	topics  map[string]*memoryTopic
+	offsets map[string]int64
+}
+
+type memoryTopic struct {
+	messages []Message
+	grown    chan struct{}
+}
+
+// NewMemory creates an empty in-memory broker
+func NewMemory() *Memory {
+	return &Memory{
+		topics:  make(map[string]*memoryTopic),
+		offsets: make(map[string]int64),
+	}
+}
+
+// topic returns the log of a topic, creating it when needed; m.mu must be held
+func (m *Memory) topic(name string) *memoryTopic {
+	topic, ok := m.topics[name]
+	if !ok {
+		topic = &memoryTopic{grown: make(chan struct{})}
+		m.topics[name] = topic
+	}
+	return topic
+}
+
+// Publish appends a message to its topic and wakes up the topic's subscribers
+func (m *Memory) Publish(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topic := m.topic(msg.Topic)
+	msg.Partition = 0
+	msg.Offset = int64(len(topic.messages))
+	msg.Timestamp = time.Now()
+	topic.messages = append(topic.messages, msg)
+
+	close(topic.grown)
+	topic.grown = make(chan struct{})
+	publishedMessages.Add(1)
+	return nil
+}
+
+// Scan visits the messages published to topic so far
+func (m *Memory) Scan(ctx context.Context, topic string, visit func(*Message) bool) error {
+	return m.ScanFrom(ctx, topic, Position{}, visit)
+}
+
+// ScanFrom visits the messages published to topic so far, starting at from
+func (m *Memory) ScanFrom(ctx context.Context, topic string, from Position, visit func(*Message) bool) error {
+	m.mu.Lock()
+	messages := m.topic(topic).messages
+	m.mu.Unlock()
+
+	for i := range messages {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		msg := messages[i]
+		if msg.Offset < from.Offset || msg.Timestamp.Before(from.Time) {
+			continue
+		}
+		if !visit(&msg) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// ScanPartition visits the messages published to topic so far, starting at
+// offset. Topics have a single partition, 0.
+func (m *Memory) ScanPartition(ctx context.Context, topic string, partition int32, offset int64, visit func(*Message) bool) error {
+	if partition != 0 {
+		return nil
+	}
+	return m.ScanFrom(ctx, topic, Position{Offset: offset}, visit)
+}
+
+// Close is a no-op; the in-memory broker lives as long as the process
+func (m *Memory) Close() error {
+	return nil
+}
+
+// Subscriber returns a subscriber in the given consumer group
+func (m *Memory) Subscriber(groupID string) Subscriber {
+	return &memorySubscriber{memory: m, groupID: groupID}
+}
+
+type memorySubscriber struct {
+	memory  *Memory
+	groupID string
+}
+
+// Subscribe delivers the topic's messages in order, starting after the last
+// message the group acknowledged
+func (s *memorySubscriber) Subscribe(ctx context.Context, topic string, handler Handler) error {
+	m := s.memory
+	groupKey := s.groupID + "/" + topic
+
+	for {
+		m.mu.Lock()
+		topicLog := m.topic(topic)
+		offset := m.offsets[groupKey]
+		grown := topicLog.grown
+		var msg *Message
+		if offset < int64(len(topicLog.messages)) {
+			next := topicLog.messages[offset]
+			msg = &next
+		}
+		m.mu.Unlock()
+
+		if msg == nil {
+			select {
+			case <-grown:
+				continue
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		if err := handler(ctx, msg); err != nil {
+			return nil
+		}
+
+		m.mu.Lock()
+		m.offsets[groupKey] = offset + 1
+		m.mu.Unlock()
+	}
+}
+
+// Close is a no-op; the group's position is kept for the next subscription
+func (s *memorySubscriber) Close() error {
+	return nil
+}
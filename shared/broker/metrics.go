@@ -0,0 +1,77 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+)
+
+// Delivery metrics of publishers, exported through expvar
+var (
+	publishedMessages = expvar.NewInt("broker_published_messages")
+	failedMessages    = expvar.NewInt("broker_failed_messages")
+	bufferedMessages  = expvar.NewInt("broker_buffered_messages")
+	rejectedMessages  = expvar.NewInt("broker_rejected_messages")
+	// Delivered and failed messages per topic, counted by TopicCallbacks
+	topicMessages = expvar.NewMap("broker_topic_messages")
+)
+
+// DeliveryCallbacks are invoked when the broker acknowledged a published
+// message or gave up on it. They run on the publisher's delivery goroutines
+// and must not block.
+type DeliveryCallbacks struct {
+	OnSuccess func(msg Message)
+	OnError   func(msg Message, err error)
+}
+
+func (c DeliveryCallbacks) success(msg Message) {
+	publishedMessages.Add(1)
+	if c.OnSuccess != nil {
+		c.OnSuccess(msg)
+	}
+}
+
+func (c DeliveryCallbacks) failure(msg Message, err error) {
+	failedMessages.Add(1)
+	if c.OnError != nil {
+		c.OnError(msg, err)
+	}
+}
+
+// TopicCallbacks counts delivered and failed messages per topic in the
+// broker_topic_messages expvar map, as "<topic>.published" and
+// "<topic>.failed", and logs the messages the broker gave up on
+func TopicCallbacks() DeliveryCallbacks {
+	return DeliveryCallbacks{
+		OnSuccess: func(msg Message) {
+			topicMessages.Add(msg.Topic+".published", 1)
+		},
+		OnError: func(msg Message, err error) {
+			topicMessages.Add(msg.Topic+".failed", 1)
+			log.Printf("Broker failed to deliver message to %s: %v", msg.Topic, err)
+		},
+	}
+}
+
+// ServeMetrics serves the expvar metrics at /debug/vars on addr until ctx is
+// cancelled. They include the command line and memory statistics of the
+// process, so addr should only be reachable from inside the network.
+func ServeMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		log.Println("Serving metrics on", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("Metrics server stopped:", err)
+		}
+	}()
+}
@@ -0,0 +1,32 @@
+package broker
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+)
+
+// topicCount reads a counter of broker_topic_messages, which lives as long
+// as the process
+func topicCount(key string) int64 {
+	if count, ok := topicMessages.Get(key).(*expvar.Int); ok {
+		return count.Value()
+	}
+	return 0
+}
+
+func TestTopicCallbacksCountPerTopic(t *testing.T) {
+	published, failed := topicCount("metrics_test.published"), topicCount("metrics_test.failed")
+
+	callbacks := TopicCallbacks()
+	callbacks.success(Message{Topic: "metrics_test"})
+	callbacks.success(Message{Topic: "metrics_test"})
+	callbacks.failure(Message{Topic: "metrics_test"}, errors.New("broker unavailable"))
+
+	if delta := topicCount("metrics_test.published") - published; delta != 2 {
+		t.Errorf("metrics_test.published grew by %d, want 2", delta)
+	}
+	if delta := topicCount("metrics_test.failed") - failed; delta != 1 {
+		t.Errorf("metrics_test.failed grew by %d, want 1", delta)
+	}
+}
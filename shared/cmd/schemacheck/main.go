@@ -0,0 +1,88 @@
+// Command schemacheck checks the Avro event schemas in shared/events/schemas
+// without a network connection. It exits with status 1 when a stored version
+// was changed or removed, when a version cannot be read together with the
+// versions before it, or when the Go event types no longer match the newest
+// schema, so it can fail a CI run before a change breaks existing consumers.
+//
+//	go run ./shared/cmd/schemacheck [-mode backward|forward|full]
+//	go run ./shared/cmd/schemacheck -update
+//	go run ./shared/cmd/schemacheck -subject friendship_event -candidate new.avsc
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"notification_system/shared/events"
+)
+
+func main() {
+	modeName := flag.String("mode", "backward", "compatibility required between versions: backward, forward or full")
+	lockPath := flag.String("lock", "shared/events/schemas/schemas.lock", "lock file recording the fingerprints of stored versions")
+	update := flag.Bool("update", false, "record new versions in the lock file")
+	subject := flag.String("subject", events.FriendshipEventSubject, "subject of -candidate")
+	candidate := flag.String("candidate", "", "check a proposed next version against the stored versions of -subject")
+	flag.Parse()
+
+	mode, err := events.ParseCompatibilityMode(*modeName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *candidate != "" {
+		data, err := os.ReadFile(*candidate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := events.Schemas.CheckCandidate(*subject, data, mode); err != nil {
+			fail("%s is not %s compatible with %s: %v", *candidate, mode, *subject, err)
+		}
+		fmt.Printf("%s is %s compatible with every stored %s version\n", *candidate, mode, *subject)
+		return
+	}
+
+	// Stored versions must stay as they were recorded
+	lock, err := os.ReadFile(*lockPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatal(err)
+	}
+	added, err := events.Schemas.CheckLock(lock)
+	if err != nil {
+		fail("%v", err)
+	}
+
+	// Every version must be compatible with the versions before it
+	if err := events.Schemas.CheckCompatibility(mode); err != nil {
+		fail("incompatible schema %v", err)
+	}
+
+	// The Go types must encode and decode with the newest schemas
+	serializer := &events.AvroSerializer{Registry: events.Schemas}
+	if err := serializer.CheckBinding(); err != nil {
+		fail("Go types do not match the newest schemas: %v", err)
+	}
+
+	if len(added) > 0 {
+		if !*update {
+			for _, stored := range added {
+				fmt.Fprintf(os.Stderr, "schema %s v%d is not in %s\n", stored.Subject, stored.Version, *lockPath)
+			}
+			fail("run with -update to record new versions")
+		}
+		if err := os.WriteFile(*lockPath, events.Schemas.Lock(), 0o644); err != nil {
+			log.Fatal(err)
+		}
+		for _, stored := range added {
+			fmt.Printf("recorded %s v%d\n", stored.Subject, stored.Version)
+		}
+	}
+
+	fmt.Printf("schemas are %s compatible\n", mode)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
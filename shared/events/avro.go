@@ -0,0 +1,231 @@
+package events
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"notification_system/shared/broker"
+
+	"github.com/hamba/avro/v2"
+)
+
+// FormatAvro writes the envelope in Avro single-object encoding, using the
+// newest schema of the event type's subject in Schemas
+const FormatAvro Format = "avro"
+
+const avroContentType = "application/avro"
+
+// Avro single-object encoding starts with these two bytes, followed by the
+// little-endian CRC-64-AVRO fingerprint of the writer schema
+var avroMarker = [2]byte{0xC3, 0x01}
+
+const avroHeaderSize = 10
+
+func init() {
+	RegisterAvroSubject(FriendshipEventSubject, "friend_request", NotificationEvent{
+		SenderID:   1,
+		ReceiverID: 2,
+		Message:    "You have received a new friend request",
+		Topic:      "friend_request",
+		Status:     "unread",
+		Priority:   PriorityNormal,
+	})
+	RegisterAvroSubject(NotificationReceiptSubject, NotificationRead, NotificationReceipt{
+		NotificationID: 1,
+		EventID:        "4f1c2a9e-7d0b-4a53-9b1e-2f6d8c0e5a71",
+		SenderID:       1,
+		ReceiverID:     2,
+		Topic:          "friend_request",
+	})
+
+	RegisterSerializer(FormatAvro, &AvroSerializer{
+		Registry: Schemas,
+		Subject:  FriendshipEventSubject,
+		TypeSubjects: map[string]string{
+			NotificationDelivered: NotificationReceiptSubject,
+			NotificationRead:      NotificationReceiptSubject,
+		},
+	})
+}
+
+// avroEnvelope is the Go form of every subject's schema, which share the
+// envelope fields and differ in their payload
+type avroEnvelope[P any] struct {
+	EventID       string    `avro:"event_id"`
+	Type          string    `avro:"type"`
+	SchemaVersion int       `avro:"schema_version"`
+	OccurredAt    time.Time `avro:"occurred_at"`
+	Producer      string    `avro:"producer"`
+	CorrelationID string    `avro:"correlation_id"`
+	Payload       P         `avro:"payload"`
+}
+
+// avroCodec converts envelopes to and from the Go form of a subject's schema
+type avroCodec interface {
+	marshal(schema avro.Schema, envelope Envelope) ([]byte, error)
+	unmarshal(schema avro.Schema, data []byte) (Envelope, error)
+	sample() (Envelope, error)
+}
+
+var avroCodecs = make(map[string]avroCodec)
+
+// RegisterAvroSubject binds the payload type P, whose avro tags name the
+// payload fields, to a schema subject. sample is an event of type sampleType
+// used to check that P still matches the subject's newest schema.
+func RegisterAvroSubject[P any](subject, sampleType string, sample P) {
+	avroCodecs[subject] = payloadCodec[P]{sampleType: sampleType, samplePayload: sample}
+}
+
+type payloadCodec[P any] struct {
+	sampleType    string
+	samplePayload P
+}
+
+func (c payloadCodec[P]) marshal(schema avro.Schema, envelope Envelope) ([]byte, error) {
+	record := avroEnvelope[P]{
+		EventID:       envelope.EventID,
+		Type:          envelope.Type,
+		SchemaVersion: envelope.SchemaVersion,
+		OccurredAt:    envelope.OccurredAt,
+		Producer:      envelope.Producer,
+		CorrelationID: envelope.CorrelationID,
+	}
+	if err := json.Unmarshal(envelope.Payload, &record.Payload); err != nil {
+		return nil, fmt.Errorf("failed to read %s event payload: %w", envelope.Type, err)
+	}
+	return avro.Marshal(schema, record)
+}
+
+func (c payloadCodec[P]) unmarshal(schema avro.Schema, data []byte) (Envelope, error) {
+	var record avroEnvelope[P]
+	if err := avro.Unmarshal(schema, data, &record); err != nil {
+		return Envelope{}, err
+	}
+
+	payload, err := json.Marshal(record.Payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		EventID:       record.EventID,
+		Type:          record.Type,
+		SchemaVersion: record.SchemaVersion,
+		OccurredAt:    record.OccurredAt,
+		Producer:      record.Producer,
+		CorrelationID: record.CorrelationID,
+		Payload:       payload,
+	}, nil
+}
+
+func (c payloadCodec[P]) sample() (Envelope, error) {
+	return NewEnvelope(c.sampleType, "schemacheck", "correlation", c.samplePayload)
+}
+
+// AvroSerializer encodes envelopes with the newest schema of their subject
+// and decodes messages written with any stored version of any subject.
+// Messages carry the fingerprint of their writer schema, so a consumer needs
+// to know every schema version producers may write with.
+type AvroSerializer struct {
+	Registry *Registry
+	// Subject is the subject of event types missing from TypeSubjects
+	Subject      string
+	TypeSubjects map[string]string
+}
+
+// subject returns the subject an event type is written with
+func (s *AvroSerializer) subject(eventType string) string {
+	if subject, ok := s.TypeSubjects[eventType]; ok {
+		return subject
+	}
+	return s.Subject
+}
+
+// Encode writes an envelope in Avro single-object encoding
+func (s *AvroSerializer) Encode(envelope Envelope) ([]byte, []broker.Header, error) {
+	subject := s.subject(envelope.Type)
+	codec, ok := avroCodecs[subject]
+	if !ok {
+		return nil, nil, fmt.Errorf("no Go type registered for schema subject %q", subject)
+	}
+
+	writer, err := s.Registry.Latest(subject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := codec.marshal(writer.Schema, envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value := make([]byte, avroHeaderSize, avroHeaderSize+len(data))
+	copy(value, avroMarker[:])
+	binary.LittleEndian.PutUint64(value[2:], writer.Fingerprint)
+	value = append(value, data...)
+
+	headers := []broker.Header{
+		{Key: []byte(contentTypeHeader), Value: []byte(avroContentType)},
+	}
+	return value, headers, nil
+}
+
+// Decode reads a message written in Avro single-object encoding
+func (s *AvroSerializer) Decode(headers map[string]string, value []byte) (Envelope, bool, error) {
+	if len(value) < avroHeaderSize || value[0] != avroMarker[0] || value[1] != avroMarker[1] {
+		return Envelope{}, false, nil
+	}
+
+	subject, reader, err := s.Registry.readerSchema(binary.LittleEndian.Uint64(value[2:avroHeaderSize]))
+	if err != nil {
+		return Envelope{}, true, err
+	}
+
+	codec, ok := avroCodecs[subject]
+	if !ok {
+		return Envelope{}, true, fmt.Errorf("no Go type registered for schema subject %q", subject)
+	}
+
+	envelope, err := codec.unmarshal(reader, value[avroHeaderSize:])
+	return envelope, true, err
+}
+
+// CheckBinding checks that the registered Go types still match the newest
+// schema of their subject by round-tripping a sample event of each
+func (s *AvroSerializer) CheckBinding() error {
+	subjects := make([]string, 0, len(avroCodecs))
+	for subject := range avroCodecs {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+
+	for _, subject := range subjects {
+		sample, err := avroCodecs[subject].sample()
+		if err != nil {
+			return err
+		}
+
+		serializer := &AvroSerializer{Registry: s.Registry, Subject: subject}
+		value, _, err := serializer.Encode(sample)
+		if err != nil {
+			return fmt.Errorf("%s: failed to encode sample event: %w", subject, err)
+		}
+
+		decoded, _, err := serializer.Decode(nil, value)
+		if err != nil {
+			return fmt.Errorf("%s: failed to decode sample event: %w", subject, err)
+		}
+
+		var want, got interface{}
+		json.Unmarshal(sample.Payload, &want)
+		json.Unmarshal(decoded.Payload, &got)
+		if !reflect.DeepEqual(want, got) || decoded.EventID != sample.EventID || decoded.Type != sample.Type {
+			return fmt.Errorf("%s: sample event changed in a round trip: %+v became %+v", subject, sample, decoded)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification_system/shared/broker"
+)
+
+const (
+	// FormatCloudEventsStructured writes a CloudEvents JSON document as the
+	// message value (Kafka protocol binding, structured content mode)
+	FormatCloudEventsStructured Format = "cloudevents-structured"
+	// FormatCloudEventsBinary writes the payload as the message value and the
+	// event attributes as ce_* headers (Kafka protocol binding, binary content mode)
+	FormatCloudEventsBinary Format = "cloudevents-binary"
+)
+
+const (
+	cloudEventsSpecVersion  = "1.0"
+	cloudEventsContentType  = "application/cloudevents+json"
+	jsonContentType         = "application/json"
+	cloudEventsHeaderPrefix = "ce_"
+)
+
+func init() {
+	RegisterSerializer(FormatCloudEventsBinary, cloudEventsBinarySerializer{})
+	RegisterSerializer(FormatCloudEventsStructured, cloudEventsStructuredSerializer{})
+}
+
+// cloudEvent is a CloudEvents 1.0 JSON document. The envelope fields without
+// a CloudEvents counterpart travel as the correlationid and schemaversion
+// extension attributes; events without schemaversion are read as SchemaVersion.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	SchemaVersion   *int            `json:"schemaversion,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// cloudEventsStructuredSerializer writes CloudEvents in structured content mode
+type cloudEventsStructuredSerializer struct{}
+
+func (cloudEventsStructuredSerializer) Encode(envelope Envelope) ([]byte, []broker.Header, error) {
+	value, err := json.Marshal(cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              envelope.EventID,
+		Source:          envelope.Producer,
+		Type:            envelope.Type,
+		Time:            envelope.OccurredAt,
+		DataContentType: jsonContentType,
+		CorrelationID:   envelope.CorrelationID,
+		SchemaVersion:   &envelope.SchemaVersion,
+		Data:            envelope.Payload,
+	})
+	headers := []broker.Header{
+		{Key: []byte(contentTypeHeader), Value: []byte(cloudEventsContentType)},
+	}
+	return value, headers, err
+}
+
+func (cloudEventsStructuredSerializer) Decode(headers map[string]string, value []byte) (Envelope, bool, error) {
+	if !strings.HasPrefix(headers[contentTypeHeader], cloudEventsContentType) && !isStructuredCloudEvent(value) {
+		return Envelope{}, false, nil
+	}
+
+	envelope, err := decodeCloudEventsStructured(value)
+	return envelope, true, err
+}
+
+// cloudEventsBinarySerializer writes CloudEvents in binary content mode
+type cloudEventsBinarySerializer struct{}
+
+func (cloudEventsBinarySerializer) Encode(envelope Envelope) ([]byte, []broker.Header, error) {
+	headers := []broker.Header{
+		{Key: []byte(contentTypeHeader), Value: []byte(jsonContentType)},
+		{Key: []byte("ce_specversion"), Value: []byte(cloudEventsSpecVersion)},
+		{Key: []byte("ce_id"), Value: []byte(envelope.EventID)},
+		{Key: []byte("ce_source"), Value: []byte(envelope.Producer)},
+		{Key: []byte("ce_type"), Value: []byte(envelope.Type)},
+		{Key: []byte("ce_time"), Value: []byte(envelope.OccurredAt.Format(time.RFC3339Nano))},
+		{Key: []byte("ce_schemaversion"), Value: []byte(strconv.Itoa(envelope.SchemaVersion))},
+	}
+	if envelope.CorrelationID != "" {
+		headers = append(headers, broker.Header{Key: []byte("ce_correlationid"), Value: []byte(envelope.CorrelationID)})
+	}
+	return envelope.Payload, headers, nil
+}
+
+func (cloudEventsBinarySerializer) Decode(headers map[string]string, value []byte) (Envelope, bool, error) {
+	if _, ok := headers[cloudEventsHeaderPrefix+"specversion"]; !ok {
+		return Envelope{}, false, nil
+	}
+
+	attributes := make(map[string]string)
+	for key, value := range headers {
+		if strings.HasPrefix(key, cloudEventsHeaderPrefix) {
+			attributes[strings.TrimPrefix(key, cloudEventsHeaderPrefix)] = value
+		}
+	}
+
+	envelope, err := decodeCloudEventsBinary(attributes, value)
+	return envelope, true, err
+}
+
+func isStructuredCloudEvent(value []byte) bool {
+	var probe struct {
+		SpecVersion string `json:"specversion"`
+	}
+	return json.Unmarshal(value, &probe) == nil && probe.SpecVersion != ""
+}
+
+func decodeCloudEventsStructured(value []byte) (Envelope, error) {
+	var event cloudEvent
+	if err := json.Unmarshal(value, &event); err != nil {
+		return Envelope{}, err
+	}
+	if event.Type == "" {
+		return Envelope{}, fmt.Errorf("cloudevent %q has no type", event.ID)
+	}
+
+	envelope := Envelope{
+		EventID:       event.ID,
+		Type:          event.Type,
+		SchemaVersion: SchemaVersion,
+		OccurredAt:    event.Time,
+		Producer:      event.Source,
+		CorrelationID: event.CorrelationID,
+		Payload:       event.Data,
+	}
+	if event.SchemaVersion != nil {
+		envelope.SchemaVersion = *event.SchemaVersion
+	}
+	return envelope, nil
+}
+
+func decodeCloudEventsBinary(attributes map[string]string, value []byte) (Envelope, error) {
+	if attributes["type"] == "" {
+		return Envelope{}, fmt.Errorf("cloudevent %q has no type", attributes["id"])
+	}
+
+	envelope := Envelope{
+		EventID:       attributes["id"],
+		Type:          attributes["type"],
+		SchemaVersion: SchemaVersion,
+		Producer:      attributes["source"],
+		CorrelationID: attributes["correlationid"],
+		Payload:       append(json.RawMessage(nil), value...),
+	}
+
+	if raw := attributes["time"]; raw != "" {
+		occurredAt, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("invalid ce_time %q: %w", raw, err)
+		}
+		envelope.OccurredAt = occurredAt
+	}
+
+	if raw := attributes["schemaversion"]; raw != "" {
+		version, err := strconv.Atoi(raw)
+		if err != nil {
+			return Envelope{}, fmt.Errorf("invalid ce_schemaversion %q: %w", raw, err)
+		}
+		envelope.SchemaVersion = version
+	}
+
+	return envelope, nil
+}
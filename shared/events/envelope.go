@@ -0,0 +1,84 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is the envelope version written by this code
+const SchemaVersion = 1
+
+// LegacySchemaVersion marks events decoded from the flat, pre-envelope format
+const LegacySchemaVersion = 0
+
+// Envelope wraps every event published to Kafka
+type Envelope struct {
+	EventID       string          `json:"event_id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Producer      string          `json:"producer"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope wraps payload in an envelope with a fresh event ID
+func NewEnvelope(eventType, producer, correlationID string, payload interface{}) (Envelope, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		EventID:       uuid.NewString(),
+		Type:          eventType,
+		SchemaVersion: SchemaVersion,
+		OccurredAt:    time.Now().UTC(),
+		Producer:      producer,
+		CorrelationID: correlationID,
+		Payload:       data,
+	}, nil
+}
+
+// Decode parses an envelope. Messages in the legacy flat format, which is a
+// bare NotificationEvent, are wrapped in an envelope with LegacySchemaVersion
+// and no event ID.
+func Decode(data []byte) (Envelope, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Envelope{}, err
+	}
+
+	if _, ok := fields["payload"]; ok {
+		var envelope Envelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return Envelope{}, err
+		}
+		if envelope.Type == "" {
+			return Envelope{}, errors.New("event envelope has no type")
+		}
+		return envelope, nil
+	}
+
+	var legacy NotificationEvent
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		Type:          legacy.Topic,
+		SchemaVersion: LegacySchemaVersion,
+		Payload:       bytes.Clone(data),
+	}, nil
+}
+
+// NotificationEvent decodes the envelope payload as a NotificationEvent
+func (e Envelope) NotificationEvent() (NotificationEvent, error) {
+	var event NotificationEvent
+	err := json.Unmarshal(e.Payload, &event)
+	return event, err
+}
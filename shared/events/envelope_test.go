@@ -0,0 +1,116 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Both services exchange events through these types, so these tests pin the
+// wire format they agree on
+
+var sampleEvent = NotificationEvent{
+	SenderID:   1,
+	ReceiverID: 2,
+	Message:    "You have received a new friend request",
+	Topic:      "friend_request",
+	Status:     "unread",
+	Priority:   PriorityNormal,
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	formats := append([]Format{FormatEnvelope}, detectOrder...)
+
+	for _, format := range formats {
+		t.Run(string(format), func(t *testing.T) {
+			envelope, err := NewEnvelope(sampleEvent.Topic, "user_service", "correlation", sampleEvent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value, headers, err := EncodeMessage(envelope, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeMessage(headers, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded.EventID != envelope.EventID || decoded.Type != envelope.Type ||
+				decoded.SchemaVersion != SchemaVersion || decoded.Producer != envelope.Producer ||
+				decoded.CorrelationID != envelope.CorrelationID {
+				t.Errorf("decoded envelope %+v, want %+v", decoded, envelope)
+			}
+			// Avro stores timestamps in microseconds
+			if !decoded.OccurredAt.Truncate(time.Microsecond).Equal(envelope.OccurredAt.Truncate(time.Microsecond)) {
+				t.Errorf("occurred_at = %v, want %v", decoded.OccurredAt, envelope.OccurredAt)
+			}
+
+			event, err := decoded.NotificationEvent()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event != sampleEvent {
+				t.Errorf("payload = %+v, want %+v", event, sampleEvent)
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyFlatFormat(t *testing.T) {
+	legacy := []byte(`{"sender_id": 1, "receiver_id": 2, "message": "You have received a new friend request", "topic": "friend_request", "status": "unread"}`)
+
+	envelope, err := DecodeMessage(nil, legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type != "friend_request" || envelope.SchemaVersion != LegacySchemaVersion || envelope.EventID != "" {
+		t.Errorf("legacy message decoded as %+v, want a friend_request envelope with LegacySchemaVersion and no event ID", envelope)
+	}
+
+	event, err := envelope.NotificationEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sampleEvent
+	want.Priority = ""
+	if event != want {
+		t.Errorf("payload = %+v, want %+v", event, want)
+	}
+}
+
+func TestDecodeRejectsInvalidMessages(t *testing.T) {
+	for name, value := range map[string]string{
+		"not json":           `{"sender_id": `,
+		"envelope sans type": `{"event_id": "1", "payload": {}}`,
+	} {
+		if _, err := Decode([]byte(value)); err == nil {
+			t.Errorf("%s: decoded without error", name)
+		}
+	}
+}
+
+func TestNotificationEventFields(t *testing.T) {
+	data, err := json.Marshal(sampleEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	want := []string{"message", "priority", "receiver_id", "sender_id", "status", "topic"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("NotificationEvent JSON fields = %v, want %v", names, want)
+	}
+}
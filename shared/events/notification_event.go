@@ -0,0 +1,22 @@
+package events
+
+// NotificationEvent is the payload of every friendship event. It is shared by
+// the producer in user_service and the consumer in notification_service.
+// The avro tags bind it to the payload of the friendship_event schema.
+type NotificationEvent struct {
+	SenderID   int    `json:"sender_id" avro:"sender_id"`
+	ReceiverID int    `json:"receiver_id" avro:"receiver_id"`
+	Message    string `json:"message" avro:"message"`
+	Topic      string `json:"topic" avro:"topic"`
+	Status     string `json:"status" avro:"status"`
+	// Priority is PriorityNormal or PriorityUrgent. Events written before it
+	// existed are read as PriorityNormal.
+	Priority string `json:"priority,omitempty" avro:"priority"`
+}
+
+// Notification priorities
+const (
+	PriorityNormal = "normal"
+	// PriorityUrgent notifications are delivered during the receiver's quiet hours
+	PriorityUrgent = "urgent"
+)
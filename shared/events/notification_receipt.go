@@ -0,0 +1,24 @@
+package events
+
+// NotificationReceiptSubject is the schema subject of events on the
+// notification events topic
+const NotificationReceiptSubject = "notification_receipt"
+
+// Notification lifecycle events published by notification_service
+const (
+	// NotificationDelivered: a notification was delivered to its receiver
+	NotificationDelivered = "notification_delivered"
+	// NotificationRead: the receiver marked a notification as read
+	NotificationRead = "notification_read"
+)
+
+// NotificationReceipt is the payload of notification lifecycle events, so
+// other systems can track delivery and read receipts
+type NotificationReceipt struct {
+	NotificationID int64 `json:"notification_id" avro:"notification_id"`
+	// EventID is the ID of the friendship event that created the notification
+	EventID    string `json:"event_id" avro:"event_id"`
+	SenderID   int    `json:"sender_id" avro:"sender_id"`
+	ReceiverID int    `json:"receiver_id" avro:"receiver_id"`
+	Topic      string `json:"topic" avro:"topic"`
+}
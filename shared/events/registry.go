@@ -0,0 +1,307 @@
+package events
+
+import (
+	"embed"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hamba/avro/v2"
+)
+
+// FriendshipEventSubject is the schema subject of events on friendship_events
+const FriendshipEventSubject = "friendship_event"
+
+// FriendshipEventTypes returns the event types of the newest friendship_event
+// schema, which are also the topics of the notifications they cause
+func FriendshipEventTypes() []string {
+	latest, err := Schemas.Latest(FriendshipEventSubject)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, field := range latest.Schema.(*avro.RecordSchema).Fields() {
+		if enum, ok := field.Type().(*avro.EnumSchema); ok && field.Name() == "type" {
+			return enum.Symbols()
+		}
+	}
+	panic("friendship_event schema has no type enum")
+}
+
+//go:embed schemas
+var schemaFiles embed.FS
+
+// Schemas is the registry of the Avro schemas stored in the schemas directory
+var Schemas = MustLoadRegistry(schemaFiles, "schemas")
+
+// CompatibilityMode selects which readers a new schema version must work with
+type CompatibilityMode string
+
+const (
+	// CompatibilityBackward requires the newest schema to read data written
+	// with every earlier version, so consumers can be upgraded first
+	CompatibilityBackward CompatibilityMode = "backward"
+	// CompatibilityForward requires every earlier schema to read data written
+	// with the newest version, so producers can be upgraded first
+	CompatibilityForward CompatibilityMode = "forward"
+	// CompatibilityFull requires both
+	CompatibilityFull CompatibilityMode = "full"
+)
+
+// StoredSchema is one stored version of a subject's schema
+type StoredSchema struct {
+	Subject     string
+	Version     int
+	Schema      avro.Schema
+	Fingerprint uint64
+}
+
+// Registry is a local schema registry. Every subject is a directory holding
+// one v<N>.avsc file per version. Stored versions must never change; a
+// change to a schema is made by adding the next version.
+type Registry struct {
+	subjects     map[string][]*StoredSchema
+	fingerprints map[uint64]*StoredSchema
+	compat       *avro.SchemaCompatibility
+	resolved     sync.Map // map[uint64]avro.Schema
+}
+
+// LoadRegistry reads the schema versions stored under dir in fsys
+func LoadRegistry(fsys fs.FS, dir string) (*Registry, error) {
+	registry := &Registry{
+		subjects:     make(map[string][]*StoredSchema),
+		fingerprints: make(map[uint64]*StoredSchema),
+		compat:       avro.NewSchemaCompatibility(),
+	}
+
+	files, err := fs.Glob(fsys, path.Join(dir, "*", "v*.avsc"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		subject := path.Base(path.Dir(file))
+		version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(path.Base(file), "v"), ".avsc"))
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("schema file %s is not named v<N>.avsc", file)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := parseSchemaVersion(subject, version, data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema %s: %w", file, err)
+		}
+
+		if existing, ok := registry.fingerprints[info.Fingerprint]; ok {
+			return nil, fmt.Errorf("schema %s v%d is identical to %s v%d", subject, version, existing.Subject, existing.Version)
+		}
+		registry.fingerprints[info.Fingerprint] = info
+		registry.subjects[subject] = append(registry.subjects[subject], info)
+	}
+
+	for subject, versions := range registry.subjects {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+		for i, info := range versions {
+			if info.Version != i+1 {
+				return nil, fmt.Errorf("subject %s is missing schema version %d", subject, i+1)
+			}
+		}
+	}
+
+	return registry, nil
+}
+
+// MustLoadRegistry is like LoadRegistry but panics on error
+func MustLoadRegistry(fsys fs.FS, dir string) *Registry {
+	registry, err := LoadRegistry(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return registry
+}
+
+// parseSchemaVersion parses a schema on its own, so named types of different
+// versions do not clash
+func parseSchemaVersion(subject string, version int, data []byte) (*StoredSchema, error) {
+	schema, err := avro.ParseBytesWithCache(data, "", &avro.SchemaCache{})
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := schema.FingerprintUsing(avro.CRC64Avro)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredSchema{
+		Subject:     subject,
+		Version:     version,
+		Schema:      schema,
+		Fingerprint: binary.BigEndian.Uint64(fingerprint),
+	}, nil
+}
+
+// Subjects returns the names of the registry's subjects
+func (r *Registry) Subjects() []string {
+	subjects := make([]string, 0, len(r.subjects))
+	for subject := range r.subjects {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+// Versions returns the stored versions of a subject, oldest first
+func (r *Registry) Versions(subject string) []*StoredSchema {
+	return r.subjects[subject]
+}
+
+// Latest returns the newest version of a subject
+func (r *Registry) Latest(subject string) (*StoredSchema, error) {
+	versions := r.subjects[subject]
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("unknown schema subject %q", subject)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// readerSchema returns the subject of the schema with the given fingerprint
+// and the schema that decodes data written with it into the newest version
+// of that subject
+func (r *Registry) readerSchema(fingerprint uint64) (string, avro.Schema, error) {
+	writer, ok := r.fingerprints[fingerprint]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown schema fingerprint %016x", fingerprint)
+	}
+
+	if schema, ok := r.resolved.Load(fingerprint); ok {
+		return writer.Subject, schema.(avro.Schema), nil
+	}
+
+	latest, err := r.Latest(writer.Subject)
+	if err != nil {
+		return "", nil, err
+	}
+
+	schema := latest.Schema
+	if writer != latest {
+		schema, err = r.compat.Resolve(latest.Schema, writer.Schema)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	r.resolved.Store(fingerprint, schema)
+	return writer.Subject, schema, nil
+}
+
+// CheckCompatibility checks that the versions of every subject are
+// compatible under mode with all the versions stored before them
+func (r *Registry) CheckCompatibility(mode CompatibilityMode) error {
+	for _, subject := range r.Subjects() {
+		versions := r.subjects[subject]
+		for i := 1; i < len(versions); i++ {
+			if err := r.checkAgainst(versions[:i], versions[i].Schema, mode); err != nil {
+				return fmt.Errorf("%s v%d: %w", subject, versions[i].Version, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckCandidate checks that a proposed next version of a subject is
+// compatible under mode with every stored version
+func (r *Registry) CheckCandidate(subject string, data []byte, mode CompatibilityMode) error {
+	candidate, err := parseSchemaVersion(subject, len(r.subjects[subject])+1, data)
+	if err != nil {
+		return err
+	}
+	return r.checkAgainst(r.subjects[subject], candidate.Schema, mode)
+}
+
+func (r *Registry) checkAgainst(previous []*StoredSchema, schema avro.Schema, mode CompatibilityMode) error {
+	for _, old := range previous {
+		if mode == CompatibilityBackward || mode == CompatibilityFull {
+			if err := r.compat.Compatible(schema, old.Schema); err != nil {
+				return fmt.Errorf("cannot read data written with v%d: %w", old.Version, err)
+			}
+		}
+		if mode == CompatibilityForward || mode == CompatibilityFull {
+			if err := r.compat.Compatible(old.Schema, schema); err != nil {
+				return fmt.Errorf("v%d readers cannot read its data: %w", old.Version, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseCompatibilityMode parses a compatibility mode, defaulting to
+// CompatibilityBackward when empty
+func ParseCompatibilityMode(name string) (CompatibilityMode, error) {
+	switch CompatibilityMode(name) {
+	case "":
+		return CompatibilityBackward, nil
+	case CompatibilityBackward, CompatibilityForward, CompatibilityFull:
+		return CompatibilityMode(name), nil
+	}
+	return "", fmt.Errorf("unknown compatibility mode %q", name)
+}
+
+// Lock returns the lock file content recording the fingerprint of every
+// stored version, one "subject version fingerprint" line each
+func (r *Registry) Lock() []byte {
+	var lock strings.Builder
+	for _, subject := range r.Subjects() {
+		for _, stored := range r.subjects[subject] {
+			fmt.Fprintf(&lock, "%s v%d %016x\n", stored.Subject, stored.Version, stored.Fingerprint)
+		}
+	}
+	return []byte(lock.String())
+}
+
+// CheckLock checks that no stored version recorded in a lock file was changed
+// or removed. It returns the versions missing from the lock file, which are
+// new ones.
+func (r *Registry) CheckLock(lock []byte) ([]*StoredSchema, error) {
+	recorded := make(map[string]string)
+	for _, line := range strings.Split(string(lock), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid schema lock line %q", line)
+		}
+		recorded[fields[0]+" "+fields[1]] = fields[2]
+	}
+
+	var added []*StoredSchema
+	for _, subject := range r.Subjects() {
+		for _, stored := range r.subjects[subject] {
+			key := fmt.Sprintf("%s v%d", stored.Subject, stored.Version)
+			fingerprint, ok := recorded[key]
+			if !ok {
+				added = append(added, stored)
+				continue
+			}
+			delete(recorded, key)
+			if fingerprint != fmt.Sprintf("%016x", stored.Fingerprint) {
+				return nil, fmt.Errorf("schema %s was changed after it was stored; add a new version instead", key)
+			}
+		}
+	}
+
+	for key := range recorded {
+		return nil, fmt.Errorf("schema %s was removed", key)
+	}
+	return added, nil
+}
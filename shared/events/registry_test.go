@@ -0,0 +1,51 @@
+package events
+
+import (
+	"os"
+	"testing"
+)
+
+// TestSchemasMatchLock runs the checks of cmd/schemacheck, so a changed or
+// incompatible schema fails go test as well
+func TestSchemasMatchLock(t *testing.T) {
+	lock, err := os.ReadFile("schemas/schemas.lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := Schemas.CheckLock(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stored := range added {
+		t.Errorf("schema %s v%d is not in schemas.lock; run go run ./shared/cmd/schemacheck -update", stored.Subject, stored.Version)
+	}
+
+	if err := Schemas.CheckCompatibility(CompatibilityBackward); err != nil {
+		t.Errorf("incompatible schema %v", err)
+	}
+
+	serializer := &AvroSerializer{Registry: Schemas}
+	if err := serializer.CheckBinding(); err != nil {
+		t.Errorf("Go types do not match the newest schemas: %v", err)
+	}
+}
+
+func TestCheckLockRejectsChangedVersions(t *testing.T) {
+	lock := Schemas.Lock()
+	if _, err := Schemas.CheckLock(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip the last digit of the first fingerprint
+	changed := append([]byte(nil), lock...)
+	for i, b := range changed {
+		if b == '\n' {
+			changed[i-1] ^= 1
+			break
+		}
+	}
+	if _, err := Schemas.CheckLock(changed); err == nil {
+		t.Error("a changed fingerprint passed the lock check")
+	}
+}
@@ -0,0 +1,97 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"notification_system/shared/broker"
+)
+
+// Format selects how events are encoded on a Kafka topic
+type Format string
+
+// FormatEnvelope writes the JSON envelope as the message value
+const FormatEnvelope Format = "envelope"
+
+const contentTypeHeader = "content-type"
+
+// Serializer encodes envelopes in one format and decodes the messages it wrote
+type Serializer interface {
+	// Encode returns the message value and headers carrying an envelope
+	Encode(envelope Envelope) ([]byte, []broker.Header, error)
+	// Decode reads a message; ok is false when the message is not in this
+	// serializer's format. headers are keyed by lower-case header name.
+	Decode(headers map[string]string, value []byte) (envelope Envelope, ok bool, err error)
+}
+
+var (
+	serializers = map[Format]Serializer{FormatEnvelope: envelopeSerializer{}}
+	// Formats tried in order when decoding; the JSON envelope is the fallback
+	detectOrder []Format
+)
+
+// RegisterSerializer makes a format available to ParseFormat, EncodeMessage
+// and DecodeMessage. It is meant to be called from init functions.
+func RegisterSerializer(format Format, serializer Serializer) {
+	if _, ok := serializers[format]; ok {
+		panic(fmt.Sprintf("event format %q registered twice", format))
+	}
+	serializers[format] = serializer
+	detectOrder = append(detectOrder, format)
+}
+
+// ParseFormat parses a format name, defaulting to FormatEnvelope when empty
+func ParseFormat(name string) (Format, error) {
+	if name == "" {
+		return FormatEnvelope, nil
+	}
+	if _, ok := serializers[Format(name)]; ok {
+		return Format(name), nil
+	}
+	return "", fmt.Errorf("unknown event format %q", name)
+}
+
+// EncodeMessage encodes an envelope as a message value and headers
+func EncodeMessage(envelope Envelope, format Format) ([]byte, []broker.Header, error) {
+	if format == "" {
+		format = FormatEnvelope
+	}
+
+	serializer, ok := serializers[format]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown event format %q", format)
+	}
+	return serializer.Encode(envelope)
+}
+
+// DecodeMessage decodes a message written in any registered format, including
+// the legacy flat format accepted by Decode
+func DecodeMessage(headers []broker.Header, value []byte) (Envelope, error) {
+	headerMap := make(map[string]string, len(headers))
+	for _, header := range headers {
+		headerMap[strings.ToLower(string(header.Key))] = string(header.Value)
+	}
+
+	for _, format := range detectOrder {
+		envelope, ok, err := serializers[format].Decode(headerMap, value)
+		if ok {
+			return envelope, err
+		}
+	}
+
+	return Decode(value)
+}
+
+// envelopeSerializer writes the JSON envelope
+type envelopeSerializer struct{}
+
+func (envelopeSerializer) Encode(envelope Envelope) ([]byte, []broker.Header, error) {
+	value, err := json.Marshal(envelope)
+	return value, nil, err
+}
+
+func (envelopeSerializer) Decode(headers map[string]string, value []byte) (Envelope, bool, error) {
+	envelope, err := Decode(value)
+	return envelope, true, err
+}
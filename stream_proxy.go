@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Headers of the client's stream request passed on to the service
+var streamedHeaders = []string{"Authorization", "Last-Event-ID", "X-Correlation-ID", "Accept"}
+
+// Client without a timeout, since streams stay open indefinitely
+var streamClient = &http.Client{}
+
+// proxyStream relays event streams from a service. proxy.Do reads the whole
+// response before answering, which never happens for a stream, so the
+// response is copied to the client as each chunk arrives.
+func proxyStream(service string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		url := service + c.Path() + "?" + c.Request().URI().QueryArgs().String()
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		for _, name := range streamedHeaders {
+			if value := c.Get(name); value != "" {
+				request.Header.Set(name, value)
+			}
+		}
+
+		response, err := streamClient.Do(request)
+		if err != nil {
+			log.Println("Failed to connect stream to service:", err)
+			return c.Status(502).JSON(fiber.Map{"error": "Service unavailable"})
+		}
+
+		c.Status(response.StatusCode)
+		for name, values := range response.Header {
+			if name == "Content-Length" || name == "Transfer-Encoding" || name == "Connection" {
+				continue
+			}
+			for _, value := range values {
+				c.Response().Header.Add(name, value)
+			}
+		}
+
+		// Closing the service's response when the client goes away ends the
+		// stream on the service too
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer response.Body.Close()
+
+			buffer := make([]byte, 4096)
+			for {
+				n, err := response.Body.Read(buffer)
+				if n > 0 {
+					if _, err := w.Write(buffer[:n]); err != nil {
+						return
+					}
+					if err := w.Flush(); err != nil {
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	}
+}
@@ -30,5 +30,5 @@ func ConnectDatabase() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
-	DB.AutoMigrate(&models.User{}, &models.Friendship{})
+	DB.AutoMigrate(&models.User{}, &models.Friendship{}, &models.PrivacySettings{}, &models.OutboxEvent{})
 }
@@ -18,10 +18,12 @@ var jwtSecret []byte
 var blacklistedTokens = make(map[string]time.Time)
 
 func init() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// Load environment variables from .env file, unless the environment
+	// already holds the secret, as in tests
+	if os.Getenv("JWT_SECRET") == "" {
+		if err := godotenv.Load(); err != nil {
+			log.Fatal("Error loading .env file")
+		}
 	}
 
 	// Get the JWT secret from environment variables
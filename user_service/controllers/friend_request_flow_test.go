@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	notificationconfig "notification_system/notification_service/config"
+	notificationevents "notification_system/notification_service/events"
+	notificationmodels "notification_system/notification_service/models"
+	"notification_system/notification_service/schedule"
+	"notification_system/shared/broker"
+	"notification_system/user_service/config"
+	"notification_system/user_service/events"
+	"notification_system/user_service/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// waitForNotifications waits until receiverID has count notifications
+func waitForNotifications(t *testing.T, receiverID uint, count int) []notificationmodels.Notification {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var notifications []notificationmodels.Notification
+		if err := notificationconfig.DB.Where("receiver_id = ?", receiverID).Order("id").Find(&notifications).Error; err != nil {
+			t.Fatal(err)
+		}
+		if len(notifications) >= count || time.Now().After(deadline) {
+			if len(notifications) != count {
+				t.Fatalf("user %d has %d notifications, want %d", receiverID, len(notifications), count)
+			}
+			return notifications
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// startNotificationService runs the outbox relay and the notification
+// consumer against an in-memory broker until the test ends
+func startNotificationService(t *testing.T) *broker.Memory {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "notifications.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&notificationmodels.Notification{}, &notificationmodels.PurgedEvent{}, &notificationmodels.NotificationPreference{},
+		&notificationmodels.QuietHours{}, &notificationmodels.DeferredDelivery{}, &notificationmodels.DeliveryTarget{}, &notificationmodels.Delivery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	notificationconfig.DB = db
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	memory := broker.NewMemory()
+	producer := events.NewProducer(memory, events.FriendshipTopic, events.ProducerOptions{})
+	go events.StartOutboxRelay(ctx, config.DB, producer)
+	go notificationevents.StartConsumer(ctx, memory.Subscriber("notification_service"), events.FriendshipTopic, notificationevents.DefaultRetryPolicy)
+	return memory
+}
+
+// TestFriendRequestFlow runs both services against the in-memory broker:
+// friendship handlers record events in the outbox, the relay publishes
+// them, and the notification consumer stores them
+func TestFriendRequestFlow(t *testing.T) {
+	app := setupFriendships(t)
+	memory := startNotificationService(t)
+	ctx := context.Background()
+
+	if status := post(t, app, "/send", alice, "bob"); status != 200 {
+		t.Fatalf("send status = %d, want 200", status)
+	}
+	request := waitForNotifications(t, bob, 1)[0]
+	if request.SenderID != int(alice) || request.Topic != string(events.FriendRequestSent) || request.Status != "unread" {
+		t.Errorf("bob was notified of %+v, want alice's friend request", request)
+	}
+
+	if status := post(t, app, "/accept", bob, "alice"); status != 200 {
+		t.Fatalf("accept status = %d, want 200", status)
+	}
+	accepted := waitForNotifications(t, alice, 1)[0]
+	if accepted.SenderID != int(bob) || accepted.Topic != string(events.FriendRequestAccepted) {
+		t.Errorf("alice was notified of %+v, want bob accepting the request", accepted)
+	}
+
+	// Blocking publishes an event, but never notifies the blocked user
+	if status := post(t, app, "/block", bob, "alice"); status != 200 {
+		t.Fatalf("block status = %d, want 200", status)
+	}
+	var published int
+	deadline := time.Now().Add(10 * time.Second)
+	for published < 3 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		published = 0
+		memory.Scan(ctx, events.FriendshipTopic, func(*broker.Message) bool {
+			published++
+			return true
+		})
+	}
+	if published != 3 {
+		t.Fatalf("%d events published, want 3", published)
+	}
+
+	// Events are consumed in order, so once carol's later request reached
+	// alice the block was consumed too
+	if err := config.DB.Create(&models.User{ID: 3, Username: "carol", Email: "carol@example.com"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if status := post(t, app, "/send", 3, "alice"); status != 200 {
+		t.Fatalf("send status = %d, want 200", status)
+	}
+	for _, notification := range waitForNotifications(t, alice, 2) {
+		if notification.Topic == string(events.Blocked) {
+			t.Errorf("alice was notified of being blocked")
+		}
+	}
+}
+
+// recordingDispatcher records the notifications dispatched out of band
+type recordingDispatcher struct {
+	mu         sync.Mutex
+	dispatched []string
+}
+
+func (d *recordingDispatcher) Dispatch(ctx context.Context, notification notificationmodels.Notification, channel string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dispatched = append(d.dispatched, notification.Topic)
+	return nil
+}
+
+func (d *recordingDispatcher) topics() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.dispatched)
+}
+
+// TestUrgentEventBypassesQuietHours checks that an accepted request reaches a
+// user in their quiet hours straight away, while a new request waits
+func TestUrgentEventBypassesQuietHours(t *testing.T) {
+	app := setupFriendships(t)
+	startNotificationService(t)
+
+	dispatcher := &recordingDispatcher{}
+	previous := schedule.Deliveries
+	schedule.Deliveries = dispatcher
+	t.Cleanup(func() { schedule.Deliveries = previous })
+
+	// Both users are quiet from an hour ago until an hour from now
+	now := time.Now().UTC()
+	for _, userID := range []uint{alice, bob} {
+		quietHours := notificationmodels.QuietHours{UserID: userID, Start: now.Add(-time.Hour).Format("15:04"), End: now.Add(time.Hour).Format("15:04"), Timezone: "UTC"}
+		if err := notificationconfig.DB.Create(&quietHours).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if status := post(t, app, "/send", alice, "bob"); status != 200 {
+		t.Fatalf("send status = %d, want 200", status)
+	}
+	waitForNotifications(t, bob, 1)
+	if status := post(t, app, "/accept", bob, "alice"); status != 200 {
+		t.Fatalf("accept status = %d, want 200", status)
+	}
+	waitForNotifications(t, alice, 1)
+
+	// Deliveries are scheduled after the notification is stored
+	deadline := time.Now().Add(10 * time.Second)
+	for len(dispatcher.topics()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	for _, topic := range dispatcher.topics() {
+		if topic != string(events.FriendRequestAccepted) {
+			t.Errorf("%s dispatched during quiet hours, want only %s", topic, events.FriendRequestAccepted)
+		}
+	}
+	if len(dispatcher.topics()) == 0 {
+		t.Errorf("nothing dispatched, want the accepted request delivered during quiet hours")
+	}
+
+	var deferred []notificationmodels.DeferredDelivery
+	if err := notificationconfig.DB.Find(&deferred).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, delivery := range deferred {
+		if delivery.ReceiverID != bob {
+			t.Errorf("delivery to user %d deferred, want only bob's friend request deferred", delivery.ReceiverID)
+		}
+	}
+	if len(deferred) == 0 {
+		t.Errorf("no deliveries deferred, want bob's friend request deferred")
+	}
+}
@@ -1,8 +1,6 @@
 package controllers
 
 import (
-	"log"
-	"os"
 	"strings"
 
 	"notification_system/user_service/config"
@@ -11,31 +9,11 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
-	"github.com/joho/godotenv"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var kafkaProducer *events.KafkaProducer
-
-func init() {
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	// Get the Kafka broker from environment variables
-	kafkaBroker := os.Getenv("KAFKA_BROKER")
-	if kafkaBroker == "" {
-		log.Fatal("KAFKA_BROKER environment variable not set")
-	}
-
-	// Initialize Kafka producer
-	kafkaProducer, err = events.NewKafkaProducer([]string{kafkaBroker}, "friendship_events")
-	if err != nil {
-		log.Fatal("Failed to create Kafka producer:", err)
-	}
-}
-
 // Helper function to retrieve user ID from JWT token
 func getUserIDFromToken(c *fiber.Ctx) (uint, error) {
 	authHeader := c.Get("Authorization")
@@ -69,6 +47,32 @@ func getUserIDFromToken(c *fiber.Ctx) (uint, error) {
 	return uint(userID), nil
 }
 
+// Helper function to retrieve the correlation ID of a request, generating one
+// when the caller did not send it
+func getCorrelationID(c *fiber.Ctx) string {
+	if correlationID := c.Get("X-Correlation-ID"); correlationID != "" {
+		return correlationID
+	}
+	return uuid.NewString()
+}
+
+// Helper function to apply a friendship change and record its event in the
+// outbox atomically. change reports how many rows it affected; nothing is
+// recorded when it affected none.
+func applyFriendshipChange(c *fiber.Ctx, eventType events.EventType, senderID, receiverID uint, change func(tx *gorm.DB) (int64, error)) (bool, error) {
+	changed := false
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		rows, err := change(tx)
+		if err != nil || rows == 0 {
+			return err
+		}
+
+		changed = true
+		return events.Enqueue(tx, events.NewEvent(eventType, senderID, receiverID), getCorrelationID(c))
+	})
+	return changed, err
+}
+
 // Send Friend Request
 func SendFriendRequest(c *fiber.Ctx) error {
 	var input struct {
@@ -93,22 +97,36 @@ func SendFriendRequest(c *fiber.Ctx) error {
 	// Check if the friendship request already exists
 	var existingFriendship models.Friendship
 	if err := config.DB.Where("(user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?)", userID, targetUser.ID, targetUser.ID, userID).First(&existingFriendship).Error; err == nil {
+		if existingFriendship.Status == "blocked" {
+			return c.Status(403).JSON(fiber.Map{"error": "Cannot send a friend request to this user"})
+		}
 		return c.Status(400).JSON(fiber.Map{"error": "Friend request already exists"})
 	}
 
+	// Check whether the target accepts friend requests from the caller
+	settings, err := loadPrivacySettings(targetUser.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch privacy settings"})
+	}
+
+	switch settings.FriendRequests {
+	case models.AudienceNobody:
+		return c.Status(403).JSON(fiber.Map{"error": "User is not accepting friend requests"})
+	case models.AudienceFriendsOfFriends:
+		if !haveMutualFriend(userID, targetUser.ID) {
+			return c.Status(403).JSON(fiber.Map{"error": "User only accepts friend requests from friends of friends"})
+		}
+	}
+
 	// Create the friend request
 	friendship := models.Friendship{UserID1: userID, UserID2: targetUser.ID, Status: "pending"}
-	config.DB.Create(&friendship)
-
-	// Publish Kafka event
-	event := events.NotificationEvent{
-		SenderID:   int(userID),
-		ReceiverID: int(targetUser.ID),
-		Message:    "You have received a new friend request",
-		Topic:      "friend_request",
-		Status:     "unread",
+	_, err = applyFriendshipChange(c, events.FriendRequestSent, userID, targetUser.ID, func(tx *gorm.DB) (int64, error) {
+		result := tx.Create(&friendship)
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to send friend request"})
 	}
-	_ = kafkaProducer.SendMessage(event)
 
 	return c.JSON(fiber.Map{"message": "Friend request sent"})
 }
@@ -154,21 +172,89 @@ func AcceptFriendRequest(c *fiber.Ctx) error {
 	}
 
 	// Accept the friend request
-	config.DB.Model(&friendship).Update("status", "accepted")
-
-	// Publish Kafka event
-	event := events.NotificationEvent{
-		SenderID:   int(userID),
-		ReceiverID: int(targetUser.ID),
-		Message:    "Your friend request has been accepted",
-		Topic:      "friend_request_accepted",
-		Status:     "unread",
+	_, err = applyFriendshipChange(c, events.FriendRequestAccepted, userID, targetUser.ID, func(tx *gorm.DB) (int64, error) {
+		result := tx.Model(&friendship).Update("status", "accepted")
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to accept friend request"})
 	}
-	_ = kafkaProducer.SendMessage(event)
 
 	return c.JSON(fiber.Map{"message": "Friend request accepted"})
 }
 
+// Decline Friend Request
+func DeclineFriendRequest(c *fiber.Ctx) error {
+	var input struct {
+		Username string `json:"username"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var targetUser models.User
+	result := config.DB.Where("username = ?", input.Username).First(&targetUser)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	// Remove the pending request the target sent to the caller
+	changed, err := applyFriendshipChange(c, events.FriendRequestDeclined, userID, targetUser.ID, func(tx *gorm.DB) (int64, error) {
+		result := tx.Where("user_id1 = ? AND user_id2 = ? AND status = ?", targetUser.ID, userID, "pending").Delete(&models.Friendship{})
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decline friend request"})
+	}
+	if !changed {
+		return c.Status(400).JSON(fiber.Map{"error": "No pending friend request found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Friend request declined"})
+}
+
+// Cancel Friend Request
+func CancelFriendRequest(c *fiber.Ctx) error {
+	var input struct {
+		Username string `json:"username"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var targetUser models.User
+	result := config.DB.Where("username = ?", input.Username).First(&targetUser)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	// Remove the pending request the caller sent to the target
+	changed, err := applyFriendshipChange(c, events.FriendRequestCancelled, userID, targetUser.ID, func(tx *gorm.DB) (int64, error) {
+		result := tx.Where("user_id1 = ? AND user_id2 = ? AND status = ?", userID, targetUser.ID, "pending").Delete(&models.Friendship{})
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to cancel friend request"})
+	}
+	if !changed {
+		return c.Status(400).JSON(fiber.Map{"error": "No pending friend request found"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Friend request cancelled"})
+}
+
 // View Friends
 func ViewFriends(c *fiber.Ctx) error {
 	userID, err := getUserIDFromToken(c)
@@ -176,8 +262,35 @@ func ViewFriends(c *fiber.Ctx) error {
 		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 
+	// Optionally view another user's friend list, subject to their privacy settings
+	ownerID := userID
+	if username := c.Query("username"); username != "" {
+		var targetUser models.User
+		if err := config.DB.Where("username = ?", username).First(&targetUser).Error; err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+		}
+
+		if targetUser.ID != userID {
+			settings, err := loadPrivacySettings(targetUser.ID)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch privacy settings"})
+			}
+
+			switch settings.FriendList {
+			case models.AudienceNobody:
+				return c.Status(403).JSON(fiber.Map{"error": "Friend list is private"})
+			case models.AudienceFriends:
+				if !areFriends(userID, targetUser.ID) {
+					return c.Status(403).JSON(fiber.Map{"error": "Friend list is only visible to friends"})
+				}
+			}
+		}
+
+		ownerID = targetUser.ID
+	}
+
 	var friends []models.Friendship
-	config.DB.Where("(user_id1 = ? OR user_id2 = ?) AND (status = ? OR status = ?)", userID, userID, "accepted", "unfollowed").Find(&friends)
+	config.DB.Where("(user_id1 = ? OR user_id2 = ?) AND status IN ?", ownerID, ownerID, friendStatuses).Find(&friends)
 
 	return c.JSON(friends)
 }
@@ -203,7 +316,16 @@ func Unfriend(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
 	}
 
-	config.DB.Where("(user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?)", userID, targetUser.ID, targetUser.ID, userID).Delete(&models.Friendship{})
+	changed, err := applyFriendshipChange(c, events.Unfriended, userID, targetUser.ID, func(tx *gorm.DB) (int64, error) {
+		result := tx.Where("((user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?)) AND status IN ?", userID, targetUser.ID, targetUser.ID, userID, friendStatuses).Delete(&models.Friendship{})
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unfriend user"})
+	}
+	if !changed {
+		return c.Status(400).JSON(fiber.Map{"error": "User is not a friend"})
+	}
 
 	return c.JSON(fiber.Map{"message": "User unfriended"})
 }
@@ -229,7 +351,16 @@ func Unfollow(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
 	}
 
-	config.DB.Model(&models.Friendship{}).Where("(user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?) AND status = ?", userID, targetUser.ID, targetUser.ID, userID, "accepted").Update("status", "unfollowed")
+	changed, err := applyFriendshipChange(c, events.Unfollowed, userID, targetUser.ID, func(tx *gorm.DB) (int64, error) {
+		result := tx.Model(&models.Friendship{}).Where("((user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?)) AND status = ?", userID, targetUser.ID, targetUser.ID, userID, "accepted").Update("status", "unfollowed")
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unfollow user"})
+	}
+	if !changed {
+		return c.Status(400).JSON(fiber.Map{"error": "User is not a followed friend"})
+	}
 
 	return c.JSON(fiber.Map{"message": "User unfollowed"})
 }
@@ -255,7 +386,61 @@ func FollowAgain(c *fiber.Ctx) error {
 		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
 	}
 
-	config.DB.Model(&models.Friendship{}).Where("(user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?) AND status = ?", userID, targetUser.ID, targetUser.ID, userID, "unfollowed").Update("status", "accepted")
+	changed, err := applyFriendshipChange(c, events.Refollowed, userID, targetUser.ID, func(tx *gorm.DB) (int64, error) {
+		result := tx.Model(&models.Friendship{}).Where("((user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?)) AND status = ?", userID, targetUser.ID, targetUser.ID, userID, "unfollowed").Update("status", "accepted")
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to follow user again"})
+	}
+	if !changed {
+		return c.Status(400).JSON(fiber.Map{"error": "User is not an unfollowed friend"})
+	}
 
 	return c.JSON(fiber.Map{"message": "User followed again"})
 }
+
+// Block
+func Block(c *fiber.Ctx) error {
+	var input struct {
+		Username string `json:"username"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var targetUser models.User
+	result := config.DB.Where("username = ?", input.Username).First(&targetUser)
+	if result.Error != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if targetUser.ID == userID {
+		return c.Status(400).JSON(fiber.Map{"error": "Cannot block yourself"})
+	}
+
+	// Replace any friendship between the two with a block owned by the
+	// caller. Blocks are kept: one the target placed on the caller stays
+	// theirs, and blocking the target again changes nothing.
+	changed, err := applyFriendshipChange(c, events.Blocked, userID, targetUser.ID, func(tx *gorm.DB) (int64, error) {
+		if err := tx.Where("((user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?)) AND status <> ?", userID, targetUser.ID, targetUser.ID, userID, "blocked").Delete(&models.Friendship{}).Error; err != nil {
+			return 0, err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Friendship{UserID1: userID, UserID2: targetUser.ID, Status: "blocked"})
+		return result.RowsAffected, result.Error
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to block user"})
+	}
+	if !changed {
+		return c.JSON(fiber.Map{"message": "User already blocked"})
+	}
+
+	return c.JSON(fiber.Map{"message": "User blocked"})
+}
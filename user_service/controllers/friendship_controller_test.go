@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sharedevents "notification_system/shared/events"
+	"notification_system/user_service/config"
+	"notification_system/user_service/events"
+	"notification_system/user_service/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Tests run without a .env file, so init reads the secret from here. Package
+// variables are initialized before init runs.
+var _ = os.Setenv("JWT_SECRET", "test-secret")
+
+const (
+	alice uint = 1
+	bob   uint = 2
+)
+
+// setupFriendships points config.DB at a fresh database holding alice and
+// bob, and returns an app serving the friendship handlers
+func setupFriendships(t *testing.T) *fiber.App {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "users.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Friendship{}, &models.PrivacySettings{}, &models.OutboxEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []models.User{{ID: alice, Username: "alice", Email: "alice@example.com"}, {ID: bob, Username: "bob", Email: "bob@example.com"}} {
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.DB = db
+
+	app := fiber.New()
+	app.Post("/send", SendFriendRequest)
+	app.Post("/accept", AcceptFriendRequest)
+	app.Post("/decline", DeclineFriendRequest)
+	app.Post("/cancel", CancelFriendRequest)
+	app.Post("/unfriend", Unfriend)
+	app.Post("/unfollow", Unfollow)
+	app.Post("/follow-again", FollowAgain)
+	app.Post("/block", Block)
+	return app
+}
+
+// post calls a friendship handler as userID with a username body and
+// returns the response status
+func post(t *testing.T, app *fiber.App, path string, userID uint, username string) int {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"userID": userID}).SignedString(jwtSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", path, strings.NewReader(`{"username": "`+username+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+// outboxEvents returns the envelopes recorded in the outbox and their events
+func outboxEvents(t *testing.T) ([]sharedevents.Envelope, []events.NotificationEvent) {
+	t.Helper()
+
+	var rows []models.OutboxEvent
+	if err := config.DB.Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	envelopes := make([]sharedevents.Envelope, len(rows))
+	notifications := make([]events.NotificationEvent, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal(row.Payload, &envelopes[i]); err != nil {
+			t.Fatal(err)
+		}
+		event, err := envelopes[i].NotificationEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		notifications[i] = event
+	}
+	return envelopes, notifications
+}
+
+func TestFriendshipHandlersEmitEvents(t *testing.T) {
+	tests := []struct {
+		name        string
+		friendships []models.Friendship
+		path        string
+		want        events.EventType
+	}{
+		{
+			name: "send",
+			path: "/send",
+			want: events.FriendRequestSent,
+		},
+		{
+			name:        "accept",
+			friendships: []models.Friendship{{UserID1: bob, UserID2: alice, Status: "pending"}},
+			path:        "/accept",
+			want:        events.FriendRequestAccepted,
+		},
+		{
+			name:        "decline",
+			friendships: []models.Friendship{{UserID1: bob, UserID2: alice, Status: "pending"}},
+			path:        "/decline",
+			want:        events.FriendRequestDeclined,
+		},
+		{
+			name:        "cancel",
+			friendships: []models.Friendship{{UserID1: alice, UserID2: bob, Status: "pending"}},
+			path:        "/cancel",
+			want:        events.FriendRequestCancelled,
+		},
+		{
+			name:        "unfriend",
+			friendships: []models.Friendship{{UserID1: bob, UserID2: alice, Status: "accepted"}},
+			path:        "/unfriend",
+			want:        events.Unfriended,
+		},
+		{
+			name:        "unfollow",
+			friendships: []models.Friendship{{UserID1: alice, UserID2: bob, Status: "accepted"}},
+			path:        "/unfollow",
+			want:        events.Unfollowed,
+		},
+		{
+			name:        "follow again",
+			friendships: []models.Friendship{{UserID1: alice, UserID2: bob, Status: "unfollowed"}},
+			path:        "/follow-again",
+			want:        events.Refollowed,
+		},
+		{
+			name:        "block",
+			friendships: []models.Friendship{{UserID1: alice, UserID2: bob, Status: "accepted"}},
+			path:        "/block",
+			want:        events.Blocked,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			app := setupFriendships(t)
+			for _, friendship := range test.friendships {
+				if err := config.DB.Create(&friendship).Error; err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if status := post(t, app, test.path, alice, "bob"); status != 200 {
+				t.Fatalf("status = %d, want 200", status)
+			}
+
+			envelopes, notifications := outboxEvents(t)
+			if len(envelopes) != 1 {
+				t.Fatalf("recorded %d events, want exactly 1", len(envelopes))
+			}
+
+			want := events.NewEvent(test.want, alice, bob)
+			if envelopes[0].Type != string(test.want) || envelopes[0].Producer != events.ProducerName || envelopes[0].EventID == "" {
+				t.Errorf("envelope = %+v, want a %s event from %s", envelopes[0], test.want, events.ProducerName)
+			}
+			if notifications[0] != want {
+				t.Errorf("event = %+v, want %+v", notifications[0], want)
+			}
+		})
+	}
+}
+
+func TestFriendshipHandlersEmitNothingWithoutChange(t *testing.T) {
+	paths := []string{"/accept", "/decline", "/cancel", "/unfriend", "/unfollow", "/follow-again"}
+
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			app := setupFriendships(t)
+
+			if status := post(t, app, path, alice, "bob"); status != 400 {
+				t.Fatalf("status = %d, want 400", status)
+			}
+			if envelopes, _ := outboxEvents(t); len(envelopes) != 0 {
+				t.Fatalf("recorded %d events for a transition that changed nothing", len(envelopes))
+			}
+		})
+	}
+}
+
+func TestBlockedEventHasNoMessage(t *testing.T) {
+	if message := events.NewEvent(events.Blocked, alice, bob).Message; message != "" {
+		t.Errorf("blocked event message = %q, want none, as it is never shown to the blocked user", message)
+	}
+}
+
+func TestBlockKeepsExistingBlocks(t *testing.T) {
+	app := setupFriendships(t)
+	if err := config.DB.Create(&models.Friendship{UserID1: bob, UserID2: alice, Status: "blocked"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocking bob back, then again, leaves bob's block in place and records
+	// alice's block once
+	for i := 0; i < 2; i++ {
+		if status := post(t, app, "/block", alice, "bob"); status != 200 {
+			t.Fatalf("status = %d, want 200", status)
+		}
+	}
+
+	var friendships []models.Friendship
+	config.DB.Order("user_id1").Find(&friendships)
+	if len(friendships) != 2 || friendships[0].UserID1 != alice || friendships[0].Status != "blocked" ||
+		friendships[1].UserID1 != bob || friendships[1].Status != "blocked" {
+		t.Errorf("friendships = %+v, want both users blocking each other", friendships)
+	}
+	if envelopes, _ := outboxEvents(t); len(envelopes) != 1 {
+		t.Errorf("recorded %d events, want one for the first block", len(envelopes))
+	}
+}
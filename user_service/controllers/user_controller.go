@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"strings"
+
+	"notification_system/user_service/config"
+	"notification_system/user_service/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// Statuses under which two users count as friends
+var friendStatuses = []string{"accepted", "unfollowed"}
+
+// Helper function to load a user's privacy settings, falling back to defaults
+func loadPrivacySettings(userID uint) (models.PrivacySettings, error) {
+	var settings models.PrivacySettings
+	result := config.DB.Where("user_id = ?", userID).Limit(1).Find(&settings)
+	if result.Error != nil {
+		return settings, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return models.DefaultPrivacySettings(userID), nil
+	}
+	return settings, nil
+}
+
+// Helper function to check whether two users are friends
+func areFriends(userA, userB uint) bool {
+	var count int64
+	config.DB.Model(&models.Friendship{}).
+		Where("((user_id1 = ? AND user_id2 = ?) OR (user_id1 = ? AND user_id2 = ?)) AND status IN ?", userA, userB, userB, userA, friendStatuses).
+		Count(&count)
+	return count > 0
+}
+
+// Helper function to check whether two users share at least one friend
+func haveMutualFriend(userA, userB uint) bool {
+	friendsOf := func(userID uint) *gorm.DB {
+		return config.DB.Model(&models.Friendship{}).
+			Select("CASE WHEN user_id1 = ? THEN user_id2 ELSE user_id1 END AS friend_id", userID).
+			Where("(user_id1 = ? OR user_id2 = ?) AND status IN ?", userID, userID, friendStatuses)
+	}
+
+	var count int64
+	config.DB.Raw("SELECT COUNT(*) FROM (?) AS a WHERE a.friend_id IN (SELECT friend_id FROM (?) AS b)", friendsOf(userA), friendsOf(userB)).
+		Scan(&count)
+	return count > 0
+}
+
+// Get Privacy Settings
+func GetPrivacySettings(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	settings, err := loadPrivacySettings(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch privacy settings"})
+	}
+
+	return c.JSON(settings)
+}
+
+// Update Privacy Settings
+func UpdatePrivacySettings(c *fiber.Ctx) error {
+	var input struct {
+		FriendRequests *string `json:"friend_requests"`
+		FriendList     *string `json:"friend_list"`
+		Discoverable   *bool   `json:"discoverable"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+	}
+
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	settings, err := loadPrivacySettings(userID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch privacy settings"})
+	}
+
+	if input.FriendRequests != nil {
+		switch *input.FriendRequests {
+		case models.AudienceEveryone, models.AudienceFriendsOfFriends, models.AudienceNobody:
+			settings.FriendRequests = *input.FriendRequests
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "friend_requests must be everyone, friends_of_friends or nobody"})
+		}
+	}
+
+	if input.FriendList != nil {
+		switch *input.FriendList {
+		case models.AudienceEveryone, models.AudienceFriends, models.AudienceNobody:
+			settings.FriendList = *input.FriendList
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "friend_list must be everyone, friends or nobody"})
+		}
+	}
+
+	if input.Discoverable != nil {
+		settings.Discoverable = *input.Discoverable
+	}
+
+	if err := config.DB.Save(&settings).Error; err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update privacy settings"})
+	}
+
+	return c.JSON(settings)
+}
+
+// Search Users by username prefix, skipping users who opted out of discovery
+// or blocked the caller
+func SearchUsers(c *fiber.Ctx) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Search query is required"})
+	}
+
+	// Escape LIKE wildcards so the query is matched literally
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+
+	type result struct {
+		ID       uint   `json:"id"`
+		Username string `json:"username"`
+	}
+
+	var users []result
+	err = config.DB.Model(&models.User{}).
+		Select("users.id, users.username").
+		Joins("LEFT JOIN privacy_settings ON privacy_settings.user_id = users.id").
+		Where("users.username LIKE ? AND users.id <> ?", pattern, userID).
+		Where("privacy_settings.user_id IS NULL OR privacy_settings.discoverable = ?", true).
+		Where("NOT EXISTS (SELECT 1 FROM friendships WHERE friendships.user_id1 = users.id AND friendships.user_id2 = ? AND friendships.status = ?)", userID, "blocked").
+		Order("users.username").
+		Limit(20).
+		Scan(&users).Error
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to search users"})
+	}
+
+	return c.JSON(users)
+}
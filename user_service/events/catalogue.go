@@ -0,0 +1,111 @@
+package events
+
+import (
+	"fmt"
+	"slices"
+
+	sharedevents "notification_system/shared/events"
+)
+
+// EventType identifies a friendship state transition published to
+// friendship_events. It is carried in the Topic field of NotificationEvent.
+//
+// Every event shares the NotificationEvent payload:
+//
+//	sender_id   - the user who performed the transition
+//	receiver_id - the other user in the friendship, who is notified, except
+//	              of Blocked events
+//	message     - human readable text for the receiver, empty for Blocked
+//	topic       - the EventType
+//	status      - always "unread"
+//	priority    - "urgent" for the event types in urgentEventTypes, which are
+//	              delivered during the receiver's quiet hours, otherwise
+//	              "normal", so quiet hours defer out-of-band delivery
+//
+// The FriendshipEventType enum of the newest friendship_event schema in
+// shared/events/schemas is the list of event types. A new one is added there
+// in a new schema version first; naming one that is not in the enum, or
+// leaving one without a message, fails at startup.
+type EventType string
+
+// EventTypes lists every event type, in the order of the schema enum
+var EventTypes = func() []EventType {
+	var eventTypes []EventType
+	for _, name := range sharedevents.FriendshipEventTypes() {
+		eventTypes = append(eventTypes, EventType(name))
+	}
+	return eventTypes
+}()
+
+// eventType returns the event type called name, which must be in the schema
+// enum
+func eventType(name string) EventType {
+	if !slices.Contains(EventTypes, EventType(name)) {
+		panic(fmt.Sprintf("event type %q is not in the %s schema", name, sharedevents.FriendshipEventSubject))
+	}
+	return EventType(name)
+}
+
+var (
+	// FriendRequestSent: sender sent a friend request to receiver
+	FriendRequestSent = eventType("friend_request")
+	// FriendRequestAccepted: sender accepted the request receiver sent earlier
+	FriendRequestAccepted = eventType("friend_request_accepted")
+	// FriendRequestDeclined: sender declined the request receiver sent earlier
+	FriendRequestDeclined = eventType("friend_request_declined")
+	// FriendRequestCancelled: sender withdrew the request they sent to receiver
+	FriendRequestCancelled = eventType("friend_request_cancelled")
+	// Unfriended: sender removed receiver from their friends
+	Unfriended = eventType("unfriended")
+	// Unfollowed: sender stopped following receiver but they remain friends
+	Unfollowed = eventType("unfollowed")
+	// Refollowed: sender followed receiver again after unfollowing
+	Refollowed = eventType("refollowed")
+	// Blocked: sender blocked receiver, removing any existing friendship.
+	// Other systems may react to it, but receiver is never notified, as
+	// telling them would defeat the block.
+	Blocked = eventType("blocked")
+)
+
+// eventMessages holds the message shown to the receiver for each event type
+var eventMessages = map[EventType]string{
+	FriendRequestSent:      "You have received a new friend request",
+	FriendRequestAccepted:  "Your friend request has been accepted",
+	FriendRequestDeclined:  "Your friend request has been declined",
+	FriendRequestCancelled: "A friend request you received has been cancelled",
+	Unfriended:             "You have been removed from a friend list",
+	Unfollowed:             "A friend has unfollowed you",
+	Refollowed:             "A friend has followed you again",
+}
+
+// urgentEventTypes are published with urgent priority. An accepted request is
+// urgent because its receiver is waiting for the answer.
+var urgentEventTypes = map[EventType]bool{
+	FriendRequestAccepted: true,
+}
+
+func init() {
+	// Every event type of the schema needs a message, except Blocked
+	for _, eventType := range EventTypes {
+		if _, ok := eventMessages[eventType]; !ok && eventType != Blocked {
+			panic(fmt.Sprintf("event type %q has no message", eventType))
+		}
+	}
+}
+
+// NewEvent builds the catalogue event for a transition performed by senderID
+// that affects receiverID
+func NewEvent(eventType EventType, senderID, receiverID uint) NotificationEvent {
+	priority := sharedevents.PriorityNormal
+	if urgentEventTypes[eventType] {
+		priority = sharedevents.PriorityUrgent
+	}
+	return NotificationEvent{
+		SenderID:   int(senderID),
+		ReceiverID: int(receiverID),
+		Message:    eventMessages[eventType],
+		Topic:      string(eventType),
+		Status:     "unread",
+		Priority:   priority,
+	}
+}
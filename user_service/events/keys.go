@@ -0,0 +1,74 @@
+package events
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// KeyStrategy selects the Kafka message key of an event. Messages with the
+// same key land on the same partition and are consumed in order.
+type KeyStrategy string
+
+const (
+	// KeyByReceiver keys events by receiver, ordering everything a user is notified about
+	KeyByReceiver KeyStrategy = "receiver"
+	// KeyBySender keys events by the user who performed the transition
+	KeyBySender KeyStrategy = "sender"
+	// KeyByPair keys events by the pair of users, in either direction
+	KeyByPair KeyStrategy = "pair"
+	// KeyNone sends events without a key, spreading them over all partitions
+	KeyNone KeyStrategy = "none"
+)
+
+// KeyStrategies maps event types to key strategies. Event types without an
+// entry are keyed by receiver.
+type KeyStrategies map[EventType]KeyStrategy
+
+// ParseKeyStrategies parses a comma separated list of type=strategy pairs,
+// such as "blocked=sender,unfriended=pair". Every type must be in EventTypes.
+func ParseKeyStrategies(spec string) (KeyStrategies, error) {
+	strategies := KeyStrategies{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		eventType, strategy, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid key strategy %q, expected type=strategy", entry)
+		}
+		if !slices.Contains(EventTypes, EventType(eventType)) {
+			return nil, fmt.Errorf("unknown event type %q in key strategy %q", eventType, entry)
+		}
+
+		switch KeyStrategy(strategy) {
+		case KeyByReceiver, KeyBySender, KeyByPair, KeyNone:
+			strategies[EventType(eventType)] = KeyStrategy(strategy)
+		default:
+			return nil, fmt.Errorf("unknown key strategy %q for %s", strategy, eventType)
+		}
+	}
+	return strategies, nil
+}
+
+// Key returns the message key of an event, or nil when it should not be keyed
+func (s KeyStrategies) Key(event NotificationEvent) []byte {
+	strategy, ok := s[EventType(event.Topic)]
+	if !ok {
+		strategy = KeyByReceiver
+	}
+
+	switch strategy {
+	case KeyBySender:
+		return []byte(strconv.Itoa(event.SenderID))
+	case KeyByPair:
+		return []byte(friendshipAggregateID(event.SenderID, event.ReceiverID))
+	case KeyNone:
+		return nil
+	default:
+		return []byte(strconv.Itoa(event.ReceiverID))
+	}
+}
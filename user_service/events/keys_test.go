@@ -0,0 +1,24 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyStrategies(t *testing.T) {
+	strategies, err := ParseKeyStrategies(" blocked=sender, unfriended=pair ,")
+	if want := (KeyStrategies{Blocked: KeyBySender, Unfriended: KeyByPair}); err != nil || !reflect.DeepEqual(strategies, want) {
+		t.Errorf("ParseKeyStrategies = %v, %v, want %v", strategies, err, want)
+	}
+
+	for _, spec := range []string{
+		"blocked",
+		"blocked=everyone",
+		// A misspelt event type would otherwise be ignored
+		"friend_requst=pair",
+	} {
+		if _, err := ParseKeyStrategies(spec); err == nil {
+			t.Errorf("ParseKeyStrategies(%q) succeeded, want an error", spec)
+		}
+	}
+}
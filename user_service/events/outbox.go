@@ -0,0 +1,183 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	sharedevents "notification_system/shared/events"
+	"notification_system/user_service/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FriendshipTopic is the Kafka topic friendship events are published to
+const FriendshipTopic = "friendship_events"
+
+const (
+	outboxBatchSize    = 100
+	outboxPollInterval = time.Second
+	outboxBaseBackoff  = time.Second
+	outboxMaxBackoff   = 5 * time.Minute
+)
+
+// Enqueue wraps an event in an envelope and records it in the outbox using tx,
+// so it is only published if the surrounding transaction commits
+func Enqueue(tx *gorm.DB, event NotificationEvent, correlationID string) error {
+	envelope, err := sharedevents.NewEnvelope(event.Topic, ProducerName, correlationID, event)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&models.OutboxEvent{
+		AggregateID:   friendshipAggregateID(event.SenderID, event.ReceiverID),
+		Topic:         FriendshipTopic,
+		Payload:       payload,
+		Status:        models.OutboxPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+// Events about the same pair of users share an aggregate so they are published in order
+func friendshipAggregateID(userA, userB int) string {
+	if userA > userB {
+		userA, userB = userB, userA
+	}
+	return fmt.Sprintf("friendship:%d:%d", userA, userB)
+}
+
+// StartOutboxRelay publishes pending outbox rows to Kafka until ctx is done.
+// Delivery is at least once: a row is only marked published after Kafka
+// acknowledged it, so a crash in between publishes it again.
+func StartOutboxRelay(ctx context.Context, db *gorm.DB, producer *Producer) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := relayOutboxBatch(db, producer); err != nil {
+				log.Println("Outbox relay failed:", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// relayOutboxBatch publishes one batch of pending rows. The rows stay locked
+// for the whole batch, so concurrent relays in other replicas wait instead of
+// publishing the same rows out of order.
+func relayOutboxBatch(db *gorm.DB, producer *Producer) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Only the first pending event of an aggregate is ever held back, and
+		// later events of the same aggregate must wait for it, so aggregates
+		// with an event held back are left out entirely. Filtering in the
+		// query keeps them from filling the batch and starving the others.
+		heldBack := tx.Model(&models.OutboxEvent{}).
+			Select("aggregate_id").
+			Where("status = ? AND next_attempt_at > ?", models.OutboxPending, now)
+
+		var pending []models.OutboxEvent
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ? AND aggregate_id NOT IN (?)", models.OutboxPending, heldBack).
+			Order("id").
+			Limit(outboxBatchSize).
+			Find(&pending).Error
+		if err != nil {
+			return err
+		}
+
+		// Group the rows by aggregate, keeping their order
+		var aggregates [][]*models.OutboxEvent
+		index := make(map[string]int)
+
+		for i := range pending {
+			event := &pending[i]
+			position, ok := index[event.AggregateID]
+			if !ok {
+				position = len(aggregates)
+				index[event.AggregateID] = position
+				aggregates = append(aggregates, nil)
+			}
+			aggregates[position] = append(aggregates[position], event)
+		}
+
+		// Publish different aggregates concurrently so the producer can batch
+		// them, and the events of each aggregate one after the other
+		updated := make([][]*models.OutboxEvent, len(aggregates))
+		var wg sync.WaitGroup
+		for i, rows := range aggregates {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				updated[i] = relayAggregate(producer, rows, now)
+			}()
+		}
+		wg.Wait()
+
+		for _, rows := range updated {
+			for _, event := range rows {
+				if err := tx.Save(event).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// relayAggregate publishes the events of one aggregate in order, stopping at
+// the first failure to publish, and returns the events it updated
+func relayAggregate(producer *Producer, rows []*models.OutboxEvent, now time.Time) []*models.OutboxEvent {
+	var updated []*models.OutboxEvent
+	for _, event := range rows {
+		var envelope sharedevents.Envelope
+		if err := json.Unmarshal(event.Payload, &envelope); err != nil {
+			// Retrying cannot repair a corrupt payload, so it must not hold
+			// back the rest of its aggregate
+			log.Printf("Outbox event %d has a corrupt payload, giving up: %v", event.ID, err)
+			event.Status = models.OutboxFailed
+			event.LastError = err.Error()
+			updated = append(updated, event)
+			continue
+		}
+
+		if err := producer.SendEnvelope(event.Topic, envelope); err != nil {
+			event.Attempts++
+			event.LastError = err.Error()
+			event.NextAttemptAt = now.Add(outboxBackoff(event.Attempts))
+			return append(updated, event)
+		}
+
+		publishedAt := time.Now()
+		event.Status = models.OutboxPublished
+		event.PublishedAt = &publishedAt
+		updated = append(updated, event)
+	}
+	return updated
+}
+
+// outboxBackoff doubles the retry delay with every failed attempt, up to outboxMaxBackoff
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > outboxMaxBackoff {
+		backoff = outboxMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,119 @@
+package events
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
+	"notification_system/user_service/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupOutbox returns a fresh database with an outbox table and a producer
+// publishing to an in-memory broker
+func setupOutbox(t *testing.T) (*gorm.DB, *broker.Memory, *Producer) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "outbox.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.OutboxEvent{}); err != nil {
+		t.Fatal(err)
+	}
+
+	memory := broker.NewMemory()
+	return db, memory, NewProducer(memory, FriendshipTopic, ProducerOptions{})
+}
+
+// enqueue records an event from sender to receiver in the outbox
+func enqueue(t *testing.T, db *gorm.DB, sender, receiver uint) {
+	t.Helper()
+	if err := Enqueue(db, NewEvent(FriendRequestSent, sender, receiver), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// published returns the events published to the friendship topic
+func published(t *testing.T, memory *broker.Memory) []NotificationEvent {
+	t.Helper()
+
+	var published []NotificationEvent
+	err := memory.Scan(context.Background(), FriendshipTopic, func(msg *broker.Message) bool {
+		envelope, err := sharedevents.DecodeMessage(msg.Headers, msg.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, err := envelope.NotificationEvent()
+		if err != nil {
+			t.Fatal(err)
+		}
+		published = append(published, event)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return published
+}
+
+func TestRelaySkipsAggregatesHeldBack(t *testing.T) {
+	db, memory, producer := setupOutbox(t)
+
+	// A full batch of events of one pair, whose first event is backing off
+	for i := 0; i < outboxBatchSize; i++ {
+		enqueue(t, db, 1, 2)
+	}
+	err := db.Model(&models.OutboxEvent{}).
+		Where("id = (?)", db.Model(&models.OutboxEvent{}).Select("MIN(id)")).
+		Updates(map[string]interface{}{"attempts": 1, "next_attempt_at": time.Now().Add(time.Hour)}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue(t, db, 3, 4)
+
+	if err := relayOutboxBatch(db, producer); err != nil {
+		t.Fatal(err)
+	}
+
+	events := published(t, memory)
+	if len(events) != 1 || events[0].SenderID != 3 || events[0].ReceiverID != 4 {
+		t.Fatalf("published %+v, want only the event of the pair that is not held back", events)
+	}
+
+	var pending int64
+	db.Model(&models.OutboxEvent{}).Where("status = ?", models.OutboxPending).Count(&pending)
+	if pending != outboxBatchSize {
+		t.Errorf("%d events pending, want the %d events of the held back pair", pending, outboxBatchSize)
+	}
+}
+
+func TestRelayFailsCorruptPayloads(t *testing.T) {
+	db, memory, producer := setupOutbox(t)
+
+	enqueue(t, db, 1, 2)
+	enqueue(t, db, 1, 2)
+	var first models.OutboxEvent
+	db.Order("id").First(&first)
+	if err := db.Model(&first).Update("payload", []byte("{not json")).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := relayOutboxBatch(db, producer); err != nil {
+		t.Fatal(err)
+	}
+
+	db.First(&first, first.ID)
+	if first.Status != models.OutboxFailed || first.LastError == "" {
+		t.Errorf("corrupt event is %s with error %q, want %s with an error", first.Status, first.LastError, models.OutboxFailed)
+	}
+	if events := published(t, memory); len(events) != 1 {
+		t.Errorf("published %d events, want the event after the corrupt one", len(events))
+	}
+}
@@ -1,63 +1,80 @@
 package events
 
 import (
-	"encoding/json"
+	"context"
 	"log"
 
-	"github.com/IBM/sarama"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
 )
 
+// ProducerName identifies this service in event envelopes
+const ProducerName = "user_service"
+
 // NotificationEvent represents the notification structure for Kafka
-type NotificationEvent struct {
-	SenderID   int    `json:"sender_id"`
-	ReceiverID int    `json:"receiver_id"`
-	Message    string `json:"message"`
-	Topic      string `json:"topic"`
-	Status     string `json:"status"`
+type NotificationEvent = sharedevents.NotificationEvent
+
+// ProducerOptions configures how a Producer encodes and keys events
+type ProducerOptions struct {
+	// Format is the encoding of events on the topic
+	Format sharedevents.Format
+	// Keys selects the message key of each event type
+	Keys KeyStrategies
 }
 
-// KafkaProducer holds the producer instance
-type KafkaProducer struct {
-	producer sarama.SyncProducer
-	topic    string
+// Producer encodes events and publishes them through a broker
+type Producer struct {
+	publisher broker.Publisher
+	topic     string
+	options   ProducerOptions
 }
 
-// NewKafkaProducer initializes a new Kafka producer
-func NewKafkaProducer(brokers []string, topic string) (*KafkaProducer, error) {
-	config := sarama.NewConfig()
-	config.Producer.Return.Successes = true
+// NewProducer initializes a producer publishing to topic through publisher
+func NewProducer(publisher broker.Publisher, topic string, options ProducerOptions) *Producer {
+	return &Producer{publisher: publisher, topic: topic, options: options}
+}
 
-	producer, err := sarama.NewSyncProducer(brokers, config)
+// SendMessage wraps a notification event in an envelope and publishes it
+func (p *Producer) SendMessage(event NotificationEvent) error {
+	envelope, err := sharedevents.NewEnvelope(event.Topic, ProducerName, "", event)
 	if err != nil {
-		return nil, err
+		log.Println("Failed to serialize message:", err)
+		return err
 	}
 
-	return &KafkaProducer{producer: producer, topic: topic}, nil
+	return p.SendEnvelope(p.topic, envelope)
 }
 
-// SendMessage sends a structured notification event to Kafka
-func (p *KafkaProducer) SendMessage(event NotificationEvent) error {
-	messageBytes, err := json.Marshal(event)
+// SendEnvelope encodes an envelope in the producer's format and publishes it
+// to the given topic, keyed according to the producer's key strategies
+func (p *Producer) SendEnvelope(topic string, envelope sharedevents.Envelope) error {
+	value, headers, err := sharedevents.EncodeMessage(envelope, p.options.Format)
 	if err != nil {
 		log.Println("Failed to serialize message:", err)
 		return err
 	}
 
-	msg := &sarama.ProducerMessage{
-		Topic: p.topic,
-		Value: sarama.ByteEncoder(messageBytes),
+	event, err := envelope.NotificationEvent()
+	if err != nil {
+		log.Println("Failed to read event payload:", err)
+		return err
 	}
 
-	_, _, err = p.producer.SendMessage(msg)
+	err = p.publisher.Publish(context.Background(), broker.Message{
+		Topic:   topic,
+		Key:     p.options.Keys.Key(event),
+		Value:   value,
+		Headers: headers,
+	})
 	if err != nil {
-		log.Println("Failed to send Kafka message:", err)
+		log.Println("Failed to publish message:", err)
 		return err
 	}
 
 	return nil
 }
 
-// Close shuts down the producer
-func (p *KafkaProducer) Close() error {
-	return p.producer.Close()
+// Close shuts down the underlying publisher
+func (p *Producer) Close() error {
+	return p.publisher.Close()
 }
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
+	"notification_system/shared/broker"
+	sharedevents "notification_system/shared/events"
 	"notification_system/user_service/config"
+	"notification_system/user_service/events"
 	"notification_system/user_service/routes"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/joho/godotenv"
@@ -20,6 +26,7 @@ func main() {
 	// Register Routes
 	routes.AuthRoutes(app)
 	routes.FriendshipRoutes(app)
+	routes.UserRoutes(app)
 
 	// Start Server
 	err := godotenv.Load()
@@ -32,7 +39,68 @@ func main() {
 		log.Fatal("USER_SERVICE_PORT environment variable not set")
 	}
 
+	// Get the message broker from environment variables
+	brokerConfig, err := broker.ConfigFromEnv(events.ProducerName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Count delivered and failed events per topic
+	brokerConfig.Producer.Callbacks = broker.TopicCallbacks()
+
+	// Get the event encoding from environment variables, defaulting to the JSON envelope
+	eventFormat, err := sharedevents.ParseFormat(os.Getenv("KAFKA_EVENT_FORMAT"))
+	if err != nil {
+		log.Fatal("Invalid KAFKA_EVENT_FORMAT:", err)
+	}
+
+	// Get the per event type key strategies from environment variables, keying by receiver by default
+	keyStrategies, err := events.ParseKeyStrategies(os.Getenv("KAFKA_KEY_STRATEGIES"))
+	if err != nil {
+		log.Fatal("Invalid KAFKA_KEY_STRATEGIES:", err)
+	}
+
+	// Initialize the event producer
+	publisher, err := broker.NewPublisher(brokerConfig)
+	if err != nil {
+		log.Fatal("Failed to create message publisher:", err)
+	}
+
+	producer := events.NewProducer(publisher, events.FriendshipTopic, events.ProducerOptions{
+		Format: eventFormat,
+		Keys:   keyStrategies,
+	})
+
+	// Stop relaying and serving on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Publish events recorded in the outbox in a separate goroutine
+	relayDone := make(chan struct{})
+	go func() {
+		events.StartOutboxRelay(ctx, config.DB, producer)
+		close(relayDone)
+	}()
+
+	// Expose publisher delivery metrics at /debug/vars on an internal address
+	if metricsAddr := os.Getenv("USER_SERVICE_METRICS_ADDR"); metricsAddr != "" {
+		broker.ServeMetrics(ctx, metricsAddr)
+	}
+
+	go func() {
+		<-ctx.Done()
+		app.Shutdown()
+	}()
+
 	log.Println("user server running on port", port)
 
-	log.Fatal(app.Listen(":" + port))
+	if err := app.Listen(":" + port); err != nil {
+		log.Fatal(err)
+	}
+
+	// Wait for the relay to finish its batch, then flush buffered messages
+	<-relayDone
+	if err := producer.Close(); err != nil {
+		log.Println("Failed to flush message publisher:", err)
+	}
 }
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Outbox statuses
+const (
+	OutboxPending   = "pending"
+	OutboxPublished = "published"
+	// OutboxFailed events can never be published, such as ones with a
+	// corrupt payload; they are kept for inspection
+	OutboxFailed = "failed"
+)
+
+// OutboxEvent is an event recorded in the same transaction as the state change
+// that caused it. The outbox relay publishes pending rows to Kafka.
+type OutboxEvent struct {
+	ID            uint   `gorm:"primaryKey;index:idx_outbox_status_id,priority:2"`
+	AggregateID   string `gorm:"type:varchar(64);index"`
+	Topic         string `gorm:"type:varchar(255)"`
+	Payload       []byte
+	Status        string `gorm:"type:varchar(16);index:idx_outbox_status_id,priority:1"`
+	Attempts      int
+	LastError     string `gorm:"type:text"`
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	PublishedAt   *time.Time
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Audience values used by PrivacySettings
+const (
+	AudienceEveryone         = "everyone"
+	AudienceFriendsOfFriends = "friends_of_friends"
+	AudienceFriends          = "friends"
+	AudienceNobody           = "nobody"
+)
+
+// PrivacySettings controls who may interact with a user. Users without a row
+// fall back to DefaultPrivacySettings.
+type PrivacySettings struct {
+	UserID         uint      `gorm:"primaryKey" json:"user_id"`
+	FriendRequests string    `gorm:"type:varchar(32)" json:"friend_requests"`
+	FriendList     string    `gorm:"type:varchar(32)" json:"friend_list"`
+	Discoverable   bool      `json:"discoverable"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// DefaultPrivacySettings returns the settings applied to users who never changed them
+func DefaultPrivacySettings(userID uint) PrivacySettings {
+	return PrivacySettings{
+		UserID:         userID,
+		FriendRequests: AudienceEveryone,
+		FriendList:     AudienceEveryone,
+		Discoverable:   true,
+	}
+}
@@ -12,8 +12,11 @@ func FriendshipRoutes(app *fiber.App) {
 	friend.Post("/send", controllers.SendFriendRequest)
 	friend.Get("/requests", controllers.ViewFriendRequests)
 	friend.Post("/accept", controllers.AcceptFriendRequest)
+	friend.Post("/decline", controllers.DeclineFriendRequest)
+	friend.Post("/cancel", controllers.CancelFriendRequest)
 	friend.Get("/list", controllers.ViewFriends)
 	friend.Post("/unfriend", controllers.Unfriend)
 	friend.Post("/unfollow", controllers.Unfollow)
 	friend.Post("/follow-again", controllers.FollowAgain)
+	friend.Post("/block", controllers.Block)
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"notification_system/user_service/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func UserRoutes(app *fiber.App) {
+	user := app.Group("/user")
+
+	user.Get("/search", controllers.SearchUsers)
+	user.Get("/privacy", controllers.GetPrivacySettings)
+	user.Put("/privacy", controllers.UpdatePrivacySettings)
+}
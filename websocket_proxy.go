@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Time allowed to forward a control frame
+const relayControlTimeout = 10 * time.Second
+
+// Headers of the client's upgrade request passed on to the service
+var relayedHeaders = []string{"Authorization", "X-Correlation-ID"}
+
+// proxyWebSocket relays WebSocket connections to a service, which proxy.Do
+// cannot do. The service is dialed before the client's connection is
+// upgraded, so the client sees the service's response when it refuses the
+// connection, for example with 401 for a missing token.
+func proxyWebSocket(service string) fiber.Handler {
+	relay := websocket.New(func(client *websocket.Conn) {
+		relayWebSocket(client.Conn, client.Locals("backend").(*fasthttpws.Conn))
+	})
+
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return c.Next()
+		}
+
+		header := http.Header{}
+		for _, name := range relayedHeaders {
+			if value := c.Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+
+		// http:// becomes ws:// and https:// becomes wss://
+		url := "ws" + strings.TrimPrefix(service, "http") + c.Path() + "?" + c.Request().URI().QueryArgs().String()
+		backend, response, err := fasthttpws.DefaultDialer.Dial(url, header)
+		if err != nil {
+			if response != nil {
+				return c.Status(response.StatusCode).JSON(fiber.Map{"error": http.StatusText(response.StatusCode)})
+			}
+			log.Println("Failed to connect WebSocket to service:", err)
+			return c.Status(502).JSON(fiber.Map{"error": "Service unavailable"})
+		}
+
+		c.Locals("backend", backend)
+		if err := relay(c); err != nil {
+			backend.Close()
+			return err
+		}
+		return nil
+	}
+}
+
+// relayWebSocket copies messages both ways until either side closes. Pings
+// of the service are passed to the client and the client's pongs back, so
+// the service's heartbeats reach the client.
+func relayWebSocket(client, backend *fasthttpws.Conn) {
+	backend.SetPingHandler(func(data string) error {
+		return client.WriteControl(fasthttpws.PingMessage, []byte(data), time.Now().Add(relayControlTimeout))
+	})
+	client.SetPongHandler(func(data string) error {
+		return backend.WriteControl(fasthttpws.PongMessage, []byte(data), time.Now().Add(relayControlTimeout))
+	})
+
+	done := make(chan struct{}, 2)
+	go func() {
+		copyMessages(client, backend)
+		done <- struct{}{}
+	}()
+	go func() {
+		copyMessages(backend, client)
+		done <- struct{}{}
+	}()
+
+	// Closing both connections ends the copy still reading
+	<-done
+	client.Close()
+	backend.Close()
+	<-done
+}
+
+// copyMessages copies messages from src to dst, then passes on the close
+// code src ended with
+func copyMessages(dst, src *fasthttpws.Conn) {
+	for {
+		messageType, data, err := src.ReadMessage()
+		if err != nil {
+			code, text := fasthttpws.CloseGoingAway, ""
+			var closeErr *fasthttpws.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code != fasthttpws.CloseAbnormalClosure {
+				code, text = closeErr.Code, closeErr.Text
+			}
+			dst.WriteControl(fasthttpws.CloseMessage, fasthttpws.FormatCloseMessage(code, text), time.Now().Add(relayControlTimeout))
+			return
+		}
+
+		if err := dst.WriteMessage(messageType, data); err != nil {
+			return
+		}
+	}
+}